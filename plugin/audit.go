@@ -0,0 +1,366 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The decisions recorded in the audit log
+const (
+	// AuditDecisionAllowed is recorded when the request is allowed
+	AuditDecisionAllowed = "allowed"
+	// AuditDecisionDenied is recorded when the request is denied
+	AuditDecisionDenied = "denied"
+	// AuditDecisionError is recorded when the request could not be authorized
+	AuditDecisionError = "error"
+)
+
+// auditReasonGranted is the reason recorded for allowed requests
+const auditReasonGranted = "granted"
+
+// AuditEvent is the audit log record of the authorization decision taken for a request
+type AuditEvent struct {
+	// Time is when the request was received
+	Time time.Time `json:"time"`
+	// Endpoint is the name of the endpoint, if configured
+	Endpoint string `json:"endpoint,omitempty"`
+	// RequestID is the ID of the request, if known
+	RequestID string `json:"request_id,omitempty"`
+	// Method is the HTTP method of the request
+	Method string `json:"method"`
+	// Path is the path of the request
+	Path string `json:"path"`
+	// Checks are the actions checked against the resources of the request
+	Checks []AuditCheck `json:"checks"`
+	// Subject is the subject of the token when it's verified, the token itself is never recorded
+	Subject string `json:"subject,omitempty"`
+	// UnverifiedSubject is the sub claim of the token when the subject isn't verified
+	UnverifiedSubject string `json:"unverified_subject,omitempty"`
+	// Decision is one of allowed, denied or error
+	Decision string `json:"decision"`
+	// Reason is granted for allowed requests, and the error code returned to the client otherwise
+	Reason string `json:"reason"`
+	// Enforced is false when the decision was not enforced because of the mode or enforce_percent
+	Enforced bool `json:"enforced"`
+	// LatencyMS is how long the authorization took in milliseconds
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// AuditCheck is an action checked against a resource, as recorded in the audit log
+type AuditCheck struct {
+	// Action is the action checked
+	Action string `json:"action"`
+	// Resource is the URN of the resource
+	Resource string `json:"resource"`
+}
+
+// newAuditEvent returns the audit event of the decision taken for the request
+func newAuditEvent(req RequestWrapper, cfg *Config, start time.Time, d authzDecision, err error, enforced bool) *AuditEvent {
+	ev := &AuditEvent{
+		Time:      start.UTC(),
+		Endpoint:  cfg.EndpointName,
+		RequestID: getRequestID(req, cfg),
+		Method:    req.Method(),
+		Path:      req.Path(),
+		Checks:    make([]AuditCheck, 0, len(d.checks)),
+		Enforced:  enforced,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	// the claim of a token which wasn't verified, or which failed before it was, is recorded
+	// apart so that it can't be mistaken for an authenticated subject
+	if d.subjectVerified || d.subjectTrusted {
+		ev.Subject = d.subject
+	} else {
+		ev.UnverifiedSubject = tokenSubject(getAuthorizationHeader(req))
+	}
+
+	for _, rc := range d.checks {
+		if rc.action == "" {
+			continue
+		}
+
+		ev.Checks = append(ev.Checks, AuditCheck{
+			Action:   rc.action,
+			Resource: rc.ref.urn(cfg.URNNamespace),
+		})
+	}
+
+	switch {
+	case err != nil:
+		ev.Decision = AuditDecisionError
+		ev.Reason = problemForError(err, cfg).Code
+	case !d.allowed:
+		ev.Decision = AuditDecisionDenied
+		ev.Reason = ErrorCodeForbidden
+	default:
+		ev.Decision = AuditDecisionAllowed
+		ev.Reason = auditReasonGranted
+	}
+
+	return ev
+}
+
+// auditSink writes audit events, encoded as JSON lines
+type auditSink interface {
+	write(line []byte)
+}
+
+// auditLogger records audit events to the configured sinks
+type auditLogger struct {
+	sinks []auditSink
+}
+
+// newAuditLogger returns a new audit logger writing to the configured sinks.
+// It returns nil if the audit log is not configured.
+func newAuditLogger(cfg *AuditConfig) (*auditLogger, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	a := &auditLogger{}
+
+	for _, sinkCfg := range cfg.Sinks {
+		sink, err := getAuditSink(sinkCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		a.sinks = append(a.sinks, sink)
+	}
+
+	return a, nil
+}
+
+// record writes the event to every sink
+func (a *auditLogger) record(ev *AuditEvent) {
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error("error encoding audit event", "error", err)
+		return
+	}
+
+	line = append(line, '\n')
+
+	for _, sink := range a.sinks {
+		sink.write(line)
+	}
+}
+
+// auditSinks holds the file and webhook sinks shared by every endpoint writing to the
+// same file or webhook.
+var auditSinks = newRegistry[auditSink]("audit sink")
+
+// getAuditSink returns the sink for the given configuration, creating it if needed.
+// The settings of the first configuration seen for a file or webhook are used.
+func getAuditSink(cfg *AuditSinkConfig) (auditSink, error) {
+	var key string
+
+	switch cfg.Type {
+	case AuditSinkLogger:
+		return loggerAuditSink{}, nil
+	case AuditSinkFile:
+		key = AuditSinkFile + " " + cfg.Path
+	case AuditSinkWebhook:
+		key = AuditSinkWebhook + " " + cfg.URL.String()
+	default:
+		return nil, fmt.Errorf("%w: unknown audit sink type %q", ErrInvalidConfig, cfg.Type)
+	}
+
+	return auditSinks.get(key, *cfg, func() (auditSink, error) {
+		if cfg.Type != AuditSinkFile {
+			return newWebhookAuditSink(cfg), nil
+		}
+
+		sink, err := newFileAuditSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return sink, nil
+	})
+}
+
+// loggerAuditSink writes audit events to the logger registered by KrakenD
+type loggerAuditSink struct{}
+
+// The events are written as is to the logger registered by KrakenD, so that they remain
+// valid JSON. They're logged as a field of a structured message otherwise.
+func (loggerAuditSink) write(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+
+	if l := getRegisteredLogger(); l != nil {
+		l.Info(logPrefix, "audit:", string(line))
+		return
+	}
+
+	logger.Info("audit", "event", string(line))
+}
+
+// fileAuditSink appends audit events to a file. The file is rotated when it would grow
+// larger than maxSize: it's renamed to <path>.1, older files are shifted to <path>.2 and
+// so on, and files beyond maxBackups are removed.
+type fileAuditSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newFileAuditSink opens the audit log file of the given configuration
+func newFileAuditSink(cfg *AuditSinkConfig) (*fileAuditSink, error) {
+	s := &fileAuditSink{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSize),
+		maxBackups: cfg.MaxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// open opens the audit log file for appending
+func (s *fileAuditSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening audit log file: %w", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *fileAuditSink) write(line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			logger.Error("error rotating audit log file", "path", s.path, "error", err)
+		}
+	}
+
+	if s.file == nil {
+		return
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	if err != nil {
+		logger.Error("error writing audit log file", "path", s.path, "error", err)
+	}
+}
+
+// rotate moves the current file to the first backup and opens a new one
+func (s *fileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		logger.Error("error closing audit log file", "path", s.path, "error", err)
+	}
+
+	s.file = nil
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return s.open()
+	}
+
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backupPath(i), s.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// backupPath returns the path of the n-th backup of the audit log file
+func (s *fileAuditSink) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+// webhookAuditSink posts audit events to an HTTP endpoint, one event per request.
+// Events are queued and sent in the background so that the webhook does not slow
+// down requests. Events are dropped when the queue is full.
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+	events chan []byte
+}
+
+// newWebhookAuditSink returns a new webhook sink and starts sending its events
+func newWebhookAuditSink(cfg *AuditSinkConfig) *webhookAuditSink {
+	s := &webhookAuditSink{
+		url: cfg.URL.String(),
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Millisecond,
+		},
+		events: make(chan []byte, cfg.BufferSize),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *webhookAuditSink) write(line []byte) {
+	select {
+	case s.events <- line:
+	default:
+		auditEventsDropped.WithLabelValues(AuditSinkWebhook).Inc()
+	}
+}
+
+// run sends the queued events
+func (s *webhookAuditSink) run() {
+	for line := range s.events {
+		if err := s.send(line); err != nil {
+			logger.Error("error sending audit event to webhook", "url", s.url, "error", err)
+			auditEventsDropped.WithLabelValues(AuditSinkWebhook).Inc()
+		}
+	}
+}
+
+// send posts a single event to the webhook
+func (s *webhookAuditSink) send(line []byte) error {
+	resp, err := s.client.Post(s.url, HTTPJSONEncoding, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditEvent(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t, "http://authz")
+	resID := uuid.New()
+	token := testJWT(`{"sub":"user-1"}`)
+
+	req := &testRequest{
+		method: http.MethodGet,
+		path:   "/tests/" + resID.String(),
+		headers: map[string][]string{
+			AuthorizationHeader:    {"Bearer " + token},
+			DefaultRequestIDHeader: {"req-1"},
+		},
+	}
+
+	d := authzDecision{
+		checks: []resolvedCheck{
+			{action: "test_get", ref: resourceRef{resourceType: "test", id: resID.String()}},
+			{},
+		},
+		subject: "user-1",
+	}
+
+	tests := []struct {
+		name                  string
+		allowed               bool
+		verified              bool
+		trusted               bool
+		err                   error
+		wantDecision          string
+		wantReason            string
+		wantSubject           string
+		wantUnverifiedSubject string
+	}{
+		{
+			name:         "allowed",
+			allowed:      true,
+			trusted:      true,
+			wantDecision: AuditDecisionAllowed,
+			wantReason:   "granted",
+			wantSubject:  "user-1",
+		},
+		{
+			name:         "denied",
+			trusted:      true,
+			wantDecision: AuditDecisionDenied,
+			wantReason:   ErrorCodeForbidden,
+			wantSubject:  "user-1",
+		},
+		{
+			name:                  "error",
+			err:                   ErrCheckingPermissions,
+			wantDecision:          AuditDecisionError,
+			wantReason:            ErrorCodeAuthzUnavailable,
+			wantUnverifiedSubject: "user-1",
+		},
+		{
+			name:         "error with verified subject",
+			verified:     true,
+			err:          ErrCheckingPermissions,
+			wantDecision: AuditDecisionError,
+			wantReason:   ErrorCodeAuthzUnavailable,
+			wantSubject:  "user-1",
+		},
+		{
+			name:                  "allowed per on_authz_error",
+			allowed:               true,
+			wantDecision:          AuditDecisionAllowed,
+			wantReason:            "granted",
+			wantUnverifiedSubject: "user-1",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := d
+			d.allowed = tt.allowed
+			d.subjectVerified = tt.verified
+			d.subjectTrusted = tt.trusted
+
+			ev := newAuditEvent(req, cfg, time.Now(), d, tt.err, true)
+
+			assert.Equal(t, "req-1", ev.RequestID)
+			assert.Equal(t, http.MethodGet, ev.Method)
+			assert.Equal(t, req.path, ev.Path)
+			assert.Equal(t, tt.wantSubject, ev.Subject)
+			assert.Equal(t, tt.wantUnverifiedSubject, ev.UnverifiedSubject)
+			assert.True(t, ev.Enforced)
+			assert.Equal(t, []AuditCheck{{Action: "test_get", Resource: "urn:infratographer:test:" + resID.String()}}, ev.Checks)
+			assert.Equal(t, tt.wantDecision, ev.Decision)
+			assert.Equal(t, tt.wantReason, ev.Reason)
+
+			line, err := json.Marshal(ev)
+			require.NoError(t, err)
+			assert.NotContains(t, string(line), token, "the token must never be recorded")
+		})
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := newFileAuditSink(&AuditSinkConfig{
+		Type:       AuditSinkFile,
+		Path:       path,
+		MaxSize:    20,
+		MaxBackups: 2,
+	})
+	require.NoError(t, err)
+
+	// two events fit in a file
+	for i := 1; i <= 7; i++ {
+		sink.write([]byte(fmt.Sprintf("event-%d\n", i)))
+	}
+
+	readFile := func(p string) string {
+		b, err := os.ReadFile(p)
+		require.NoError(t, err)
+
+		return string(b)
+	}
+
+	assert.Equal(t, "event-7\n", readFile(path))
+	assert.Equal(t, "event-5\nevent-6\n", readFile(path+".1"))
+	assert.Equal(t, "event-3\nevent-4\n", readFile(path+".2"))
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, errors.Is(err, os.ErrNotExist), "backups beyond max_backups should be removed")
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan string, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, HTTPJSONEncoding, r.Header.Get("Content-Type"))
+
+		received <- string(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	sink := newWebhookAuditSink(&AuditSinkConfig{
+		Type:       AuditSinkWebhook,
+		URL:        mustParseURL(t, srv.URL),
+		Timeout:    1000,
+		BufferSize: 10,
+	})
+
+	a := &auditLogger{sinks: []auditSink{sink}}
+	a.record(&AuditEvent{Method: http.MethodGet, Path: "/tests", Decision: AuditDecisionAllowed})
+
+	select {
+	case body := <-received:
+		var ev AuditEvent
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(body)), &ev))
+		assert.Equal(t, "/tests", ev.Path)
+		assert.Equal(t, AuditDecisionAllowed, ev.Decision)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook did not receive the audit event")
+	}
+}
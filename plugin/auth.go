@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
 	"net/textproto"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
-	authclientv1 "go.infratographer.com/permissions-api/pkg/client/v1"
-	"go.infratographer.com/x/urnx"
+	"golang.org/x/exp/slog"
 )
 
 const (
@@ -33,9 +32,10 @@ var (
 )
 
 type HTTPResponseError struct {
-	Code         int    `json:"http_status_code"`
-	Msg          string `json:"http_body,omitempty"`
-	HTTPEncoding string `json:"http_encoding"`
+	Code         int                 `json:"http_status_code"`
+	Msg          string              `json:"http_body,omitempty"`
+	HTTPEncoding string              `json:"http_encoding"`
+	HTTPHeaders  map[string][]string `json:"http_headers,omitempty"`
 }
 
 // Error returns the error message
@@ -53,6 +53,11 @@ func (r HTTPResponseError) Encoding() string {
 	return r.HTTPEncoding
 }
 
+// Headers returns the HTTP headers to add to the response
+func (r HTTPResponseError) Headers() map[string][]string {
+	return r.HTTPHeaders
+}
+
 // getAuthorizationHeader returns the value of the Authorization header from the given request
 func getAuthorizationHeader(req RequestWrapper) string {
 	return getHeader(req, AuthorizationHeader)
@@ -73,70 +78,360 @@ func getHeader(req RequestWrapper, header string) string {
 	return req.Headers()[header][0]
 }
 
-// tokenRoundTripper is a round tripper that adds the authorization token to the request
-// It is used to create the authz client.
-// Note that token validation is not performed here, it is performed by an earlier
-// plugin in the API Gateway, as well as the authorization service.
-// Note that this simple round tripper was created to avoid conflicting
-// dependencies with https://pkg.go.dev/golang.org/x/oauth2 which is used by the
-// krakend plugin builder.
-type tokenRoundTripper struct {
-	token string
-	trans http.RoundTripper
+// authzHandler holds the state shared by every request handled for a single
+// endpoint configuration. It is built once when the plugin factory runs.
+type authzHandler struct {
+	cfg        *Config
+	cache      *decisionCache
+	breaker    *circuitBreaker
+	authorizer Authorizer
+	audit      *auditLogger
+	verifier   *jwtVerifier
+	introspect *introspector
+
+	log          *slog.Logger
+	allowSampler *logSampler
 }
 
-func newTokenRoundTripper(token string, trans http.RoundTripper) *tokenRoundTripper {
-	return &tokenRoundTripper{
-		token: token,
-		trans: trans,
+// newAuthzHandler returns a new authzHandler for the given configuration
+func newAuthzHandler(cfg *Config) (*authzHandler, error) {
+	authorizer, err := newAuthorizer(cfg.AuthorizationService)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := newAuditLogger(cfg.Audit)
+	if err != nil {
+		return nil, err
 	}
+
+	log := newEndpointLogger(cfg)
+
+	// static policies are meant for development, where tokens aren't always verified
+	if cfg.AuthorizationService.Type == AuthzServiceTypeStatic && cfg.JWT == nil && cfg.Introspection == nil {
+		log.Warn("the static policy matches the subjects of unverified tokens, jwt or introspection must be set outside of development")
+	}
+
+	// metrics are not worth failing requests for
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		log.Error("error serving metrics", "listen_address", cfg.Metrics.ListenAddress, "error", err)
+	}
+
+	return &authzHandler{
+		cfg:        cfg,
+		cache:      newDecisionCache(cfg.Cache),
+		breaker:    getCircuitBreaker(cfg.AuthorizationService),
+		authorizer: authorizer,
+		audit:      audit,
+		verifier:   newJWTVerifier(cfg.JWT),
+		introspect: getIntrospector(cfg.Introspection),
+
+		log:          log,
+		allowSampler: &logSampler{n: uint64(cfg.LogAllowSample)},
+	}, nil
+}
+
+// resolvedCheck is a check resolved against a request: the action and the resource
+// to check it against. An empty action means the check denies the request.
+type resolvedCheck struct {
+	action string
+	ref    resourceRef
 }
 
-// RoundTrip adds the authorization token to the request and calls the default transport
-// to perform the request.
-func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set(AuthorizationHeader, t.token)
-	return t.trans.RoundTrip(req)
+// checkResult is the outcome of a single resolved check
+type checkResult struct {
+	decision Decision
+	err      error
+}
+
+// authzDecision is the outcome of the authorization of a request
+type authzDecision struct {
+	// allowed is whether the request is authorized
+	allowed bool
+	// checks are the checks resolved for the request, they are recorded in the audit log
+	checks []resolvedCheck
+	// headers are added to the request sent to the backend when it's allowed
+	headers map[string][]string
+	// subject is the subject of the token. It's verified by porton when JWT verification
+	// or token introspection is configured, and otherwise only by the permissions-api.
+	subject string
+	// subjectVerified is whether the subject was verified by porton, by JWT verification or
+	// token introspection
+	subjectVerified bool
+	// subjectTrusted is whether the subject was verified by porton, or read from a token the
+	// permissions-api authenticated when taking the decision. It's never trusted on decisions
+	// taken per on_authz_error.
+	subjectTrusted bool
+	// clientID is the client the token was issued to, it's only known for introspected tokens
+	clientID string
+	// scopes are the scopes granted to the token, from its introspection or from its scope
+	// or scp claim. They're verified like the subject.
+	scopes []string
 }
 
 // handleAuthorizationRequest handles the authorization request
-// It returns a boolean indicating whether the request is authorized and an error
-func handleAuthorizationRequest(ctx context.Context, req RequestWrapper, cfg *Config) (bool, error) {
-	resourceId := getResourceID(req, cfg.ResourceParam)
-	if resourceId == "" {
-		return false, ErrNoValidResourceID
+// It returns the decision taken for the request and an error. The decision holds the
+// checks resolved before the error occurred, if any.
+func (h *authzHandler) handleAuthorizationRequest(ctx context.Context, req RequestWrapper) (authzDecision, error) {
+	d := authzDecision{
+		checks: make([]resolvedCheck, 0, len(h.cfg.Checks)),
 	}
 
-	resUUID, err := uuid.Parse(resourceId)
-	if err != nil {
-		return false, ErrInvalidResourceUUID
-	}
+	for _, check := range h.cfg.Checks {
+		rc, err := h.resolveCheck(req, check)
+		if err != nil {
+			return d, err
+		}
 
-	urn, err := urnx.Build("infratrographer", cfg.ResourceType, resUUID)
-	if err != nil {
-		logger.Error("error building urn from resource type and id", err)
-		return false, ErrInvalidResourceUUID
+		d.checks = append(d.checks, rc)
 	}
 
 	btok := getAuthorizationHeader(req)
 	if btok == "" {
-		return false, ErrNoValidToken
+		return d, ErrNoValidToken
+	}
+
+	// verifiedClaims are the claims of the token when porton verified it
+	var verifiedClaims map[string]interface{}
+
+	switch tok := bearerToken(btok); {
+	case h.verifier != nil && (h.introspect == nil || isJWT(tok)):
+		claims, err := h.verifier.verify(ctx, tok)
+		if err != nil {
+			return d, err
+		}
+
+		// the signature of the token is verified, and so are the claims read from it
+		verifiedClaims = unverifiedClaims(btok)
+
+		d.subject = claims.Subject
+		d.subjectVerified = true
+		d.scopes = tokenScopes(verifiedClaims)
+	case h.introspect != nil:
+		info, err := h.introspect.introspect(ctx, tok)
+		if err != nil {
+			return d, err
+		}
+
+		// the claims of the token are those of the introspection response
+		verifiedClaims = info.claims
+
+		d.subject = info.subject
+		d.subjectVerified = true
+		d.clientID = info.clientID
+		d.scopes = info.scopes
+	default:
+		claims := unverifiedClaims(btok)
+		d.subject, _ = claims["sub"].(string)
+		d.scopes = tokenScopes(claims)
 	}
 
-	httpcli := &http.Client{
-		Transport: newTokenRoundTripper(btok, http.DefaultTransport),
+	// scopes are a cheap first gate, checked before calling the authorization service
+	if missing := missingScopes(h.cfg.RequiredScopes, d.scopes); len(missing) > 0 {
+		return d, fmt.Errorf("%w: missing %s", ErrInsufficientScope, strings.Join(missing, " "))
 	}
-	authzcli, err := authclientv1.New(cfg.AuthorizationService.Endpoint.String(), httpcli)
+
+	caller := AuthzRequest{
+		Token:   btok,
+		Request: req,
+	}
+
+	// authorizers can't tell claims porton didn't verify apart, so they're not passed on
+	if d.subjectVerified {
+		caller.Subject = d.subject
+		caller.ClientID = d.clientID
+		caller.Scopes = d.scopes
+		caller.Claims = verifiedClaims
+	}
+
+	var (
+		decision Decision
+		err      error
+	)
+
+	if len(d.checks) == 1 {
+		decision, err = h.checkResource(ctx, caller, d.checks[0])
+	} else {
+		decision, err = h.evaluateChecks(ctx, caller, d.checks)
+	}
+
+	d.allowed = decision.Allowed
+	d.headers = decision.Headers
+	d.subjectTrusted = err == nil && !decision.fallback &&
+		(d.subjectVerified || h.cfg.AuthorizationService.Type == AuthzServiceTypePermissionsAPI)
+
+	return d, err
+}
+
+// resolveCheck resolves the action and resource of the check for the given request
+func (h *authzHandler) resolveCheck(req RequestWrapper, check *Check) (resolvedCheck, error) {
+	action := check.actionFor(req.Method())
+	if action == "" {
+		h.log.Warn("no action configured for method", "method", req.Method(), "resource_type", check.ResourceType)
+		return resolvedCheck{}, nil
+	}
+
+	resourceId := getResourceIDFromSource(req, check.ResourceSource)
+	if resourceId == "" {
+		return resolvedCheck{}, ErrNoValidResourceID
+	}
+
+	ref, err := parseResourceID(h.cfg, check, resourceId)
 	if err != nil {
-		return false, ErrCreatingAuthzClient
+		return resolvedCheck{}, err
 	}
 
-	allowed, err := authzcli.Allowed(ctx, cfg.Action, urn.String())
+	return resolvedCheck{
+		action: action,
+		ref:    ref,
+	}, nil
+}
+
+// evaluateChecks runs the checks concurrently and combines their results according to the
+// checks mode. Outstanding checks are cancelled as soon as the outcome is known.
+// Errors only determine the outcome when no check decided it. The headers of the allowing
+// decisions are combined.
+func (h *authzHandler) evaluateChecks(ctx context.Context, caller AuthzRequest, checks []resolvedCheck) (Decision, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan checkResult, len(checks))
+
+	for _, rc := range checks {
+		go func(rc resolvedCheck) {
+			decision, err := h.checkResource(ctx, caller, rc)
+			results <- checkResult{decision: decision, err: err}
+		}(rc)
+	}
+
+	anyMode := h.cfg.ChecksMode == ChecksModeAny
+
+	var (
+		firstErr error
+		headers  map[string][]string
+		fallback bool
+	)
+
+	for range checks {
+		res := <-results
+
+		switch {
+		case res.err != nil:
+			if firstErr == nil {
+				firstErr = res.err
+			}
+		case res.decision.Allowed && anyMode:
+			return res.decision, nil
+		case !res.decision.Allowed && !anyMode:
+			return Decision{}, nil
+		default:
+			headers = mergeHeaders(headers, res.decision.Headers)
+			fallback = fallback || res.decision.fallback
+		}
+	}
+
+	if firstErr != nil {
+		return Decision{}, firstErr
+	}
+
+	// every check allowed the request in all mode, none did in any mode
+	if anyMode {
+		return Decision{}, nil
+	}
+
+	return Decision{Allowed: true, Headers: headers, fallback: fallback}, nil
+}
+
+// checkResource checks whether the caller is allowed to perform the action of the resolved check
+// on its resource, also checking under the compat URN namespace when configured.
+// The caller holds the token and subject of the request.
+func (h *authzHandler) checkResource(ctx context.Context, caller AuthzRequest, rc resolvedCheck) (Decision, error) {
+	if rc.action == "" {
+		return Decision{}, nil
+	}
+
+	decision, err := h.checkPermission(ctx, caller, rc.action, rc.ref.urn(h.cfg.URNNamespace))
+	if err != nil || decision.Allowed || h.cfg.URNCompatNamespace == "" {
+		return decision, err
+	}
+
+	// During a namespace migration, permissions may still be granted under the old namespace
+	compatURN := rc.ref.urn(h.cfg.URNCompatNamespace)
+
+	decision, err = h.checkPermission(ctx, caller, rc.action, compatURN)
+	if decision.Allowed {
+		h.log.Info("allowed using compat urn namespace", "action", rc.action, "resource", compatURN)
+	}
+
+	return decision, err
+}
+
+// checkPermission asks the authorizer whether the caller is allowed to perform the action on
+// the resource URN, using the decision cache when possible.
+func (h *authzHandler) checkPermission(ctx context.Context, caller AuthzRequest, action, urn string) (Decision, error) {
+	cacheKey := decisionCacheKey(caller.Token, action, urn)
+	if allowed, ok := h.cache.get(cacheKey); ok {
+		cacheRequests.WithLabelValues("hit").Inc()
+		return Decision{Allowed: allowed}, nil
+	}
+
+	if h.cache != nil {
+		cacheRequests.WithLabelValues("miss").Inc()
+	}
+
+	if err := h.breaker.allow(); err != nil {
+		allowed, err := h.handleAuthzError(cacheKey, err)
+		return Decision{Allowed: allowed, fallback: err == nil}, err
+	}
+
+	caller.Action = action
+	caller.Resource = urn
+
+	start := time.Now()
+	decision, err := h.authorizer.Authorize(ctx, &caller)
+	authzRequestDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
-		return false, fmt.Errorf("%w: %v", ErrCheckingPermissions, err)
+		// checks cancelled because the outcome of the request is already known are not failures
+		if errors.Is(err, context.Canceled) {
+			h.breaker.release()
+			return Decision{}, err
+		}
+
+		// errors caused by the request, such as 4xx answers to invalid tokens, must not let
+		// a few clients open the circuit shared by every endpoint using the service
+		if isAuthzServiceFailure(err) {
+			h.breaker.failure()
+		} else {
+			h.breaker.release()
+		}
+
+		allowed, err := h.handleAuthzError(cacheKey, fmt.Errorf("%w: %w", ErrCheckingPermissions, err))
+
+		return Decision{Allowed: allowed, fallback: err == nil}, err
+	}
+
+	h.breaker.success()
+
+	h.cache.set(cacheKey, decision.Allowed)
+
+	return decision, nil
+}
+
+// mergeHeaders adds the values of the headers in src to dst, returning dst
+func mergeHeaders(dst, src map[string][]string) map[string][]string {
+	if len(src) == 0 {
+		return dst
 	}
 
-	return allowed, nil
+	if dst == nil {
+		dst = make(map[string][]string, len(src))
+	}
+
+	for name, values := range src {
+		dst[name] = append(dst[name], values...)
+	}
+
+	return dst
 }
 
 // getResourceID returns the resource ID from the request
@@ -151,3 +446,32 @@ func getResourceID(req RequestWrapper, paramName string) string {
 
 	return req.Params()[p]
 }
+
+// handleAuthzError applies the configured on_authz_error policy to an error returned
+// by the authorization service. The policy only applies to failures of the service,
+// errors caused by the request are always returned.
+func (h *authzHandler) handleAuthzError(cacheKey string, err error) (bool, error) {
+	if !isAuthzServiceFailure(err) {
+		return false, err
+	}
+
+	switch h.cfg.OnAuthzError {
+	case OnAuthzErrorAllow:
+		h.log.Error("authorization service failed, allowing request per on_authz_error policy", "error", err)
+		authzErrorFallbacks.WithLabelValues(OnAuthzErrorAllow).Inc()
+
+		return true, nil
+	case OnAuthzErrorAllowStale:
+		allowed, ok := h.cache.getStale(cacheKey)
+		if !ok {
+			return false, err
+		}
+
+		h.log.Error("authorization service failed, using stale decision per on_authz_error policy", "allowed", allowed, "error", err)
+		authzErrorFallbacks.WithLabelValues(OnAuthzErrorAllowStale).Inc()
+
+		return allowed, nil
+	default:
+		return false, err
+	}
+}
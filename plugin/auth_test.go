@@ -0,0 +1,654 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.infratographer.com/x/urnx"
+)
+
+// testRequest is a RequestWrapper used in tests
+type testRequest struct {
+	params  map[string]string
+	headers map[string][]string
+	body    io.ReadCloser
+	method  string
+	url     *url.URL
+	query   url.Values
+	path    string
+}
+
+func (r *testRequest) Params() map[string]string    { return r.params }
+func (r *testRequest) Headers() map[string][]string { return r.headers }
+func (r *testRequest) Body() io.ReadCloser          { return r.body }
+func (r *testRequest) Method() string               { return r.method }
+func (r *testRequest) URL() *url.URL                { return r.url }
+func (r *testRequest) Query() url.Values            { return r.query }
+func (r *testRequest) Path() string                 { return r.path }
+
+// fakePermissionsAPI is a fake permissions-api server which allows the configured
+// action and resource pairs. Like the permissions-api, it rejects resources which are
+// not valid URNs with a 400, and the token "invalid" with a 401.
+type fakePermissionsAPI struct {
+	*fakeServer
+
+	allowed map[string]bool
+}
+
+func newFakePermissionsAPI(t *testing.T) *fakePermissionsAPI {
+	t.Helper()
+
+	f := &fakePermissionsAPI{
+		allowed: map[string]bool{},
+	}
+
+	f.fakeServer = newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/allow" || r.Header.Get(AuthorizationHeader) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get(AuthorizationHeader) == "Bearer invalid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if _, err := urnx.Parse(r.URL.Query().Get("resource")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !f.allowed[r.URL.Query().Get("action")+" "+r.URL.Query().Get("resource")] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_, _ = w.Write([]byte("{}"))
+	})
+
+	return f
+}
+
+func (f *fakePermissionsAPI) allow(action, urn string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.allowed[action+" "+urn] = true
+}
+
+// authorize returns whether the handler allows the request
+func authorize(h *authzHandler, req RequestWrapper) (bool, error) {
+	d, err := h.handleAuthorizationRequest(context.Background(), req)
+
+	return d.allowed, err
+}
+
+func newTestCheck(action, resourceType, param string) *Check {
+	return &Check{
+		Action:            action,
+		ResourceType:      resourceType,
+		ResourceIDFormats: []string{ResourceIDFormatUUID},
+		ResourceParam:     param,
+		ResourceSource: &ResourceSource{
+			Type: ResourceSourceParam,
+			Name: param,
+		},
+	}
+}
+
+func newTestConfig(t *testing.T, endpoint string) *Config {
+	t.Helper()
+
+	return &Config{
+		AuthorizationService: &AuthzService{
+			Type:                AuthzServiceTypePermissionsAPI,
+			Endpoint:            mustParseURL(t, endpoint),
+			Timeout:             1000,
+			MaxIdleConns:        1,
+			MaxIdleConnsPerHost: 1,
+			IdleConnTimeout:     1000,
+			DialTimeout:         1000,
+			TLSHandshakeTimeout: 1000,
+		},
+		Checks: []*Check{
+			newTestCheck("test_get", "test", "test_id"),
+		},
+		ChecksMode:            ChecksModeAll,
+		URNNamespace:          "infratographer",
+		OnAuthzError:          OnAuthzErrorDeny,
+		InvalidResourceStatus: http.StatusBadRequest,
+		RequestIDHeader:       DefaultRequestIDHeader,
+		Mode:                  ModeEnforce,
+		EnforcePercent:        100,
+		EnforcePercentBy:      EnforcePercentBySubject,
+		LogLevel:              "info",
+		LogAllowSample:        1,
+	}
+}
+
+func TestHandleAuthorizationRequest(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	urn := "urn:infratographer:test:" + resID.String()
+
+	api.allow("test_get", urn)
+	api.allow("test_delete", urn)
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.Checks[0].Actions = map[string]string{
+		"DELETE": "test_delete",
+		"PUT":    "test_update",
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string][]string
+		params  map[string]string
+		want    bool
+		wantErr error
+	}{
+		{
+			name:    "fallback action allowed",
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": resID.String()},
+			want:    true,
+		},
+		{
+			name:    "mapped action allowed",
+			method:  http.MethodDelete,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": resID.String()},
+			want:    true,
+		},
+		{
+			name:    "mapped action denied",
+			method:  http.MethodPut,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": resID.String()},
+			want:    false,
+		},
+		{
+			name:    "missing token",
+			method:  http.MethodGet,
+			params:  map[string]string{"Test_id": resID.String()},
+			wantErr: ErrNoValidToken,
+		},
+		{
+			name:    "missing resource id",
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			wantErr: ErrNoValidResourceID,
+		},
+		{
+			name:    "invalid resource id",
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": "not-a-uuid"},
+			wantErr: ErrInvalidResourceUUID,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := &testRequest{
+				method:  tt.method,
+				headers: tt.headers,
+				params:  tt.params,
+			}
+
+			got, err := authorize(h, req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestUnmappedMethod(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.Checks[0].Action = ""
+	cfg.Checks[0].Actions = map[string]string{
+		"GET": "test_get",
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	req := &testRequest{
+		method:  http.MethodPost,
+		headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+		params:  map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	allowed, err := authorize(h, req)
+	require.NoError(t, err)
+	assert.False(t, allowed, "unmapped methods without a fallback action should be denied")
+	assert.Zero(t, api.callCount(), "permissions-api should not be called for unmapped methods")
+}
+
+func TestHandleAuthorizationRequestCreate(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	tenantID := uuid.New()
+	api.allow("loadbalancer_create", "urn:infratographer:tenant:"+tenantID.String())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.Checks[0] = newTestCheck("", "loadbalancer", "tenant_id")
+	cfg.Checks[0].Create = &CreateCheck{
+		ParentResourceType: "tenant",
+		Action:             "loadbalancer_create",
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		tenantID string
+		want     bool
+	}{
+		{
+			name:     "allowed on parent",
+			tenantID: tenantID.String(),
+			want:     true,
+		},
+		{
+			name:     "denied on other parent",
+			tenantID: uuid.NewString(),
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := &testRequest{
+				method:  http.MethodPost,
+				headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+				params:  map[string]string{"Tenant_id": tt.tenantID},
+			}
+
+			allowed, err := authorize(h, req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, allowed)
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestCached(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:infratographer:test:"+resID.String())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.Cache = &CacheConfig{
+		Size:     10,
+		AllowTTL: 60000,
+		DenyTTL:  60000,
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	req := &testRequest{
+		method:  http.MethodGet,
+		headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+		params:  map[string]string{"Test_id": resID.String()},
+	}
+
+	for i := 0; i < 3; i++ {
+		allowed, err := authorize(h, req)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	assert.Equal(t, 1, api.callCount(), "expected decisions to be served from the cache")
+}
+
+func TestHandleAuthorizationRequestCompatNamespace(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	legacyID := uuid.New()
+	api.allow("test_get", "urn:infratrographer:test:"+legacyID.String())
+
+	migratedID := uuid.New()
+	api.allow("test_get", "urn:staging:test:"+migratedID.String())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.URNNamespace = "staging"
+	cfg.URNCompatNamespace = "infratrographer"
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	for _, id := range []uuid.UUID{legacyID, migratedID} {
+		req := &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": id.String()},
+		}
+
+		allowed, err := authorize(h, req)
+		require.NoError(t, err)
+		assert.True(t, allowed, "expected %s to be allowed under either namespace", id)
+	}
+
+	req := &testRequest{
+		method:  http.MethodGet,
+		headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+		params:  map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	allowed, err := authorize(h, req)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestHandleAuthorizationRequestOnAuthzError(t *testing.T) {
+	t.Parallel()
+
+	allowedID := uuid.New()
+	deniedID := uuid.New()
+	unknownID := uuid.New()
+
+	newReq := func(id uuid.UUID) *testRequest {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": id.String()},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		id     uuid.UUID
+		want   bool
+		errors bool
+	}{
+		{name: "deny", policy: OnAuthzErrorDeny, id: allowedID, errors: true},
+		{name: "allow", policy: OnAuthzErrorAllow, id: unknownID, want: true},
+		{name: "allow_stale with allow decision", policy: OnAuthzErrorAllowStale, id: allowedID, want: true},
+		{name: "allow_stale with deny decision", policy: OnAuthzErrorAllowStale, id: deniedID, want: false},
+		{name: "allow_stale without decision", policy: OnAuthzErrorAllowStale, id: unknownID, errors: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakePermissionsAPI(t)
+			api.allow("test_get", "urn:infratographer:test:"+allowedID.String())
+
+			cfg := newTestConfig(t, api.URL)
+			cfg.OnAuthzError = tt.policy
+			cfg.Cache = &CacheConfig{
+				Size:     10,
+				AllowTTL: 1,
+				DenyTTL:  1,
+				StaleTTL: 60000,
+			}
+
+			h, err := newAuthzHandler(cfg)
+			require.NoError(t, err)
+
+			// warm up the cache while the permissions-api is healthy
+			for _, id := range []uuid.UUID{allowedID, deniedID} {
+				_, err := authorize(h, newReq(id))
+				require.NoError(t, err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			api.setFailing(true)
+
+			allowed, err := authorize(h, newReq(tt.id))
+			if tt.errors {
+				assert.ErrorIs(t, err, ErrCheckingPermissions)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, allowed)
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestOnAuthzErrorClientErrors(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	for _, policy := range []string{OnAuthzErrorAllow, OnAuthzErrorAllowStale} {
+		policy := policy
+
+		t.Run(policy, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := newTestConfig(t, api.URL)
+			cfg.OnAuthzError = policy
+			cfg.Cache = &CacheConfig{
+				Size:     10,
+				AllowTTL: 60000,
+				StaleTTL: 60000,
+			}
+
+			h, err := newAuthzHandler(cfg)
+			require.NoError(t, err)
+
+			allowed, err := authorize(h, &testRequest{
+				method:  http.MethodGet,
+				headers: map[string][]string{AuthorizationHeader: {"Bearer invalid"}},
+				params:  map[string]string{"Test_id": uuid.NewString()},
+			})
+			assert.ErrorIs(t, err, ErrInvalidToken, "expected tokens rejected by the permissions-api not to be let through")
+			assert.False(t, allowed)
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+	api.setFailing(true)
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.AuthorizationService.CircuitBreaker = &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      60000,
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	req := &testRequest{
+		method:  http.MethodGet,
+		headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+		params:  map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := authorize(h, req)
+		assert.ErrorIs(t, err, ErrCheckingPermissions)
+	}
+
+	_, err = authorize(h, req)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, api.callCount(), "expected the open circuit to short-circuit calls to the permissions-api")
+}
+
+func TestHandleAuthorizationRequestCircuitBreakerClientErrors(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:infratographer:test:"+resID.String())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.AuthorizationService.CircuitBreaker = &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      60000,
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(token string) *testRequest {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + token}},
+			params:  map[string]string{"Test_id": resID.String()},
+		}
+	}
+
+	// 401 answers to an invalid token
+	for i := 0; i < 3; i++ {
+		_, err := authorize(h, newReq("invalid"))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	}
+
+	// 403 answers to a denied request
+	for i := 0; i < 3; i++ {
+		allowed, err := authorize(h, &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params:  map[string]string{"Test_id": uuid.NewString()},
+		})
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	}
+
+	allowed, err := authorize(h, newReq("token"))
+	require.NoError(t, err, "expected 401 and 403 answers not to open the circuit")
+	assert.True(t, allowed)
+}
+
+func TestHandleAuthorizationRequestMultipleChecks(t *testing.T) {
+	t.Parallel()
+
+	tenantID := uuid.New()
+	otherTenantID := uuid.New()
+	lbID := uuid.New()
+
+	api := newFakePermissionsAPI(t)
+	api.allow("tenant_get", "urn:infratographer:tenant:"+tenantID.String())
+	api.allow("loadbalancer_get", "urn:infratographer:loadbalancer:"+lbID.String())
+
+	newReq := func(tenant uuid.UUID) *testRequest {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			params: map[string]string{
+				"Tenant_id": tenant.String(),
+				"Lb_id":     lbID.String(),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mode   string
+		tenant uuid.UUID
+		want   bool
+	}{
+		{name: "all allowed", mode: ChecksModeAll, tenant: tenantID, want: true},
+		{name: "all with one denied", mode: ChecksModeAll, tenant: otherTenantID, want: false},
+		{name: "any with one allowed", mode: ChecksModeAny, tenant: otherTenantID, want: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := newTestConfig(t, api.URL)
+			cfg.ChecksMode = tt.mode
+			cfg.Checks = []*Check{
+				newTestCheck("tenant_get", "tenant", "tenant_id"),
+				newTestCheck("loadbalancer_get", "loadbalancer", "lb_id"),
+			}
+
+			h, err := newAuthzHandler(cfg)
+			require.NoError(t, err)
+
+			allowed, err := authorize(h, newReq(tt.tenant))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, allowed)
+		})
+	}
+
+	t.Run("any with none allowed", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := newTestConfig(t, api.URL)
+		cfg.ChecksMode = ChecksModeAny
+		cfg.Checks = []*Check{
+			newTestCheck("tenant_admin", "tenant", "tenant_id"),
+			newTestCheck("loadbalancer_delete", "loadbalancer", "lb_id"),
+		}
+
+		h, err := newAuthzHandler(cfg)
+		require.NoError(t, err)
+
+		allowed, err := authorize(h, newReq(tenantID))
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	})
+
+	t.Run("missing resource id of one check", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := newTestConfig(t, api.URL)
+		cfg.Checks = []*Check{
+			newTestCheck("tenant_get", "tenant", "tenant_id"),
+			newTestCheck("project_get", "project", "project_id"),
+		}
+
+		h, err := newAuthzHandler(cfg)
+		require.NoError(t, err)
+
+		_, err = authorize(h, newReq(tenantID))
+		assert.ErrorIs(t, err, ErrNoValidResourceID)
+	})
+}
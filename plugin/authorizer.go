@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	authclientv1 "go.infratographer.com/permissions-api/pkg/client/v1"
+)
+
+var (
+	// ErrBadAuthzResponse is returned when the authorization service returns a response
+	// which cannot be interpreted
+	ErrBadAuthzResponse = errors.New("bad response from authorization service")
+	// ErrAuthzRequestRejected is returned when the authorization service rejects the request
+	// as invalid, e.g. because of a malformed resource or token
+	ErrAuthzRequestRejected = errors.New("request rejected by authorization service")
+)
+
+// maxDecisionResponseSize is the maximum size of the responses of HTTP decision endpoints in bytes
+const maxDecisionResponseSize = 1 << 20
+
+// authzStatusError is returned when the authorization service answers with an error status
+// code. 401 responses wrap ErrInvalidToken and other 4xx responses ErrAuthzRequestRejected:
+// they're caused by the request rather than by a failure of the service.
+type authzStatusError struct {
+	code int
+}
+
+// Error returns the error message
+func (e *authzStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
+
+// Unwrap returns the error the status code stands for, if any
+func (e *authzStatusError) Unwrap() error {
+	switch {
+	case e.code == http.StatusUnauthorized:
+		return ErrInvalidToken
+	case e.code >= http.StatusBadRequest && e.code < http.StatusInternalServerError:
+		return ErrAuthzRequestRejected
+	default:
+		return nil
+	}
+}
+
+// isAuthzServiceFailure reports whether the error is a failure of the authorization service:
+// an open circuit, a transport error, a timeout, a 5xx response or a response which cannot be
+// interpreted. Other errors, such as the 4xx responses caused by the request, must not be
+// handled by the on_authz_error policy.
+func isAuthzServiceFailure(err error) bool {
+	var (
+		statusErr *authzStatusError
+		netErr    net.Error
+	)
+
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.code >= http.StatusInternalServerError
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return true
+	case errors.Is(err, ErrBadAuthzResponse), errors.Is(err, authclientv1.ErrBadResponse):
+		return true
+	default:
+		return false
+	}
+}
+
+// AuthzRequest is a request for an authorization decision
+type AuthzRequest struct {
+	// Token is the value of the Authorization header of the request
+	Token string
+	// Subject is the subject of the token. It's only set when porton verified the token, by
+	// JWT verification or token introspection.
+	Subject string
+	// ClientID is the client the token was issued to, it's only set for introspected tokens
+	ClientID string
+	// Scopes are the scopes granted to the token, from its introspection or from its scope
+	// or scp claim. They're only set like the subject.
+	Scopes []string
+	// Claims are the claims of the token, they're only set like the subject
+	Claims map[string]interface{}
+	// Action is the action checked
+	Action string
+	// Resource is the URN of the resource the action is checked on
+	Resource string
+	// Request is the request being authorized
+	Request RequestWrapper
+}
+
+// Decision is the decision of an Authorizer
+type Decision struct {
+	// Allowed is whether the action is allowed on the resource
+	Allowed bool
+	// Headers are added to the request sent to the backend when it's allowed
+	Headers map[string][]string
+
+	// fallback is whether the decision was taken per on_authz_error, the authorization
+	// service having failed
+	fallback bool
+}
+
+// Authorizer decides whether the subject of a request is allowed to perform an action on
+// a resource. The authorizer is selected by the authz_service type.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *AuthzRequest) (Decision, error)
+}
+
+// newAuthorizer returns the authorizer of the given authorization service
+func newAuthorizer(svc *AuthzService) (Authorizer, error) {
+	switch svc.Type {
+	case AuthzServiceTypeStatic:
+		return newStaticAuthorizer(svc)
+	case AuthzServiceTypeExtAuthz:
+		return newExtAuthzAuthorizer(svc), nil
+	case AuthzServiceTypeHTTP:
+		return &httpAuthorizer{
+			endpoint: svc.Endpoint.String(),
+			client:   newAuthzHTTPClient(svc),
+		}, nil
+	default:
+		cli, err := authclientv1.New(svc.Endpoint.String(), permissionsAPIDoer{client: newAuthzHTTPClient(svc)})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCreatingAuthzClient, err)
+		}
+
+		return &permissionsAPIAuthorizer{cli: cli}, nil
+	}
+}
+
+// permissionsAPIAuthorizer takes decisions with the permissions-api
+type permissionsAPIAuthorizer struct {
+	cli *authclientv1.Client
+}
+
+// Authorize asks the permissions-api whether the token is allowed to perform the action
+func (a *permissionsAPIAuthorizer) Authorize(ctx context.Context, req *AuthzRequest) (Decision, error) {
+	allowed, err := a.cli.Allowed(contextWithToken(ctx, req.Token), req.Action, req.Resource)
+
+	return Decision{Allowed: allowed}, err
+}
+
+// permissionsAPIDoer returns an *authzStatusError for the error responses of the permissions-api
+// other than 403 denials, which its client reports as ErrBadResponse whatever their cause
+type permissionsAPIDoer struct {
+	client *http.Client
+}
+
+// Do sends the request to the permissions-api
+func (d permissionsAPIDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusForbidden {
+		// drain the body so that the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDecisionResponseSize))
+		resp.Body.Close()
+
+		return nil, &authzStatusError{code: resp.StatusCode}
+	}
+
+	return resp, nil
+}
+
+// httpDecisionRequest is the body posted to HTTP decision endpoints
+type httpDecisionRequest struct {
+	Subject  string   `json:"subject,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Action   string   `json:"action"`
+	Resource string   `json:"resource"`
+}
+
+// httpDecisionResponse is the body returned by HTTP decision endpoints
+type httpDecisionResponse struct {
+	Allowed *bool `json:"allowed"`
+}
+
+// httpAuthorizer takes decisions with a generic HTTP JSON decision endpoint. The action,
+// resource and, when porton verified the token, subject are posted as JSON along with the
+// Authorization header of the request, and the endpoint answers with {"allowed": true} or
+// {"allowed": false}.
+type httpAuthorizer struct {
+	endpoint string
+	client   *http.Client
+}
+
+// Authorize asks the decision endpoint whether the action is allowed
+func (a *httpAuthorizer) Authorize(ctx context.Context, req *AuthzRequest) (Decision, error) {
+	body, err := json.Marshal(httpDecisionRequest{
+		Subject:  req.Subject,
+		ClientID: req.ClientID,
+		Scopes:   req.Scopes,
+		Action:   req.Action,
+		Resource: req.Resource,
+	})
+	if err != nil {
+		return Decision{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(contextWithToken(ctx, req.Token), http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, &authzStatusError{code: resp.StatusCode}
+	}
+
+	var decision httpDecisionResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDecisionResponseSize)).Decode(&decision); err != nil || decision.Allowed == nil {
+		return Decision{}, ErrBadAuthzResponse
+	}
+
+	return Decision{Allowed: *decision.Allowed}, nil
+}
@@ -0,0 +1,305 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authclientv1 "go.infratographer.com/permissions-api/pkg/client/v1"
+)
+
+func TestHTTPAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpDecisionRequest
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch req.Resource {
+		case "urn:infratographer:test:failing":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "urn:infratographer:test:garbage":
+			_, _ = w.Write([]byte("not json"))
+		default:
+			allowed := r.Header.Get(AuthorizationHeader) == "Bearer token" && req.Subject == "user-1" && req.Action == "test_get"
+			_ = json.NewEncoder(w).Encode(map[string]bool{"allowed": allowed})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(t, server.URL)
+	cfg.AuthorizationService.Type = AuthzServiceTypeHTTP
+
+	a, err := newAuthorizer(cfg.AuthorizationService)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		req       *AuthzRequest
+		want      bool
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "allowed",
+			req:  &AuthzRequest{Token: "Bearer token", Subject: "user-1", Action: "test_get", Resource: "urn:infratographer:test:1"},
+			want: true,
+		},
+		{
+			name: "denied",
+			req:  &AuthzRequest{Token: "Bearer token", Subject: "user-1", Action: "test_delete", Resource: "urn:infratographer:test:1"},
+			want: false,
+		},
+		{
+			name: "token forwarded",
+			req:  &AuthzRequest{Token: "Bearer other", Subject: "user-1", Action: "test_get", Resource: "urn:infratographer:test:1"},
+			want: false,
+		},
+		{
+			name:    "server error",
+			req:     &AuthzRequest{Token: "Bearer token", Action: "test_get", Resource: "urn:infratographer:test:failing"},
+			wantErr: true,
+		},
+		{
+			name:      "bad response",
+			req:       &AuthzRequest{Token: "Bearer token", Action: "test_get", Resource: "urn:infratographer:test:garbage"},
+			wantErr:   true,
+			wantErrIs: ErrBadAuthzResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := a.Authorize(context.Background(), tt.req)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				if tt.wantErrIs != nil {
+					assert.ErrorIs(t, err, tt.wantErrIs)
+				}
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, d.Allowed)
+		})
+	}
+}
+
+func TestIsAuthzServiceFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &authzStatusError{code: http.StatusBadGateway}, want: true},
+		{name: "unauthorized", err: &authzStatusError{code: http.StatusUnauthorized}, want: false},
+		{name: "bad request", err: &authzStatusError{code: http.StatusBadRequest}, want: false},
+		{name: "circuit open", err: &circuitOpenError{retryAfter: time.Second}, want: true},
+		{name: "timeout", err: fmt.Errorf("%w: %w", ErrCheckingPermissions, context.DeadlineExceeded), want: true},
+		{name: "transport error", err: &url.Error{Op: "Get", URL: "http://authz", Err: errors.New("connection refused")}, want: true},
+		{name: "bad response", err: ErrBadAuthzResponse, want: true},
+		{name: "permissions-api bad response", err: fmt.Errorf("%w: %w", ErrCheckingPermissions, authclientv1.ErrBadResponse), want: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, isAuthzServiceFailure(tt.err))
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestBadResponses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		authzType string
+		handler   http.HandlerFunc
+	}{
+		{
+			name:      "http decision endpoint answering garbage",
+			authzType: AuthzServiceTypeHTTP,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("not json"))
+			},
+		},
+		{
+			name:      "permissions-api answering an unexpected status",
+			authzType: AuthzServiceTypePermissionsAPI,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusMultipleChoices)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newFakeServer(t, tt.handler)
+
+			cfg := newTestConfig(t, server.URL)
+			cfg.AuthorizationService.Type = tt.authzType
+			cfg.AuthorizationService.CircuitBreaker = &CircuitBreakerConfig{
+				FailureThreshold: 1,
+				OpenTimeout:      60000,
+			}
+			cfg.OnAuthzError = OnAuthzErrorAllow
+
+			h, err := newAuthzHandler(cfg)
+			require.NoError(t, err)
+
+			req := &testRequest{
+				method:  http.MethodGet,
+				headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+				params:  map[string]string{"Test_id": uuid.NewString()},
+			}
+
+			allowed, err := authorize(h, req)
+			require.NoError(t, err)
+			assert.True(t, allowed, "expected the bad response to be handled by on_authz_error")
+
+			allowed, err = authorize(h, req)
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, 1, server.callCount(), "expected the bad response to open the circuit")
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestHTTPSubject(t *testing.T) {
+	t.Parallel()
+
+	var received httpDecisionRequest
+
+	server := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		received = httpDecisionRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		_ = json.NewEncoder(w).Encode(map[string]bool{"allowed": true})
+	})
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	jwks := newTestJWKS(t, key.jwk())
+
+	newHandler := func(verified bool) *authzHandler {
+		cfg := newTestConfig(t, server.URL)
+		cfg.AuthorizationService.Type = AuthzServiceTypeHTTP
+
+		if verified {
+			cfg.JWT = newTestJWTConfig(t, jwks.URL)
+		}
+
+		h, err := newAuthzHandler(cfg)
+		require.NoError(t, err)
+
+		return h
+	}
+
+	token := key.sign(t, map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"aud":   "porton",
+		"sub":   "user-1",
+		"scope": "test:read",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+
+	req := &testRequest{
+		method:  http.MethodGet,
+		headers: map[string][]string{AuthorizationHeader: {"Bearer " + token}},
+		params:  map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	tests := []struct {
+		name        string
+		verified    bool
+		wantSubject string
+		wantScopes  []string
+	}{
+		{
+			name:        "verified token",
+			verified:    true,
+			wantSubject: "user-1",
+			wantScopes:  []string{"test:read"},
+		},
+		{
+			name: "token not verified by porton",
+		},
+	}
+
+	for _, tt := range tests {
+		allowed, err := authorize(newHandler(tt.verified), req)
+		require.NoError(t, err, tt.name)
+		assert.True(t, allowed, tt.name)
+
+		server.mu.Lock()
+		assert.Equal(t, tt.wantSubject, received.Subject, tt.name)
+		assert.Equal(t, tt.wantScopes, received.Scopes, tt.name)
+		server.mu.Unlock()
+	}
+}
+
+func TestHandleAuthorizationRequestStatic(t *testing.T) {
+	t.Parallel()
+
+	resID := uuid.New()
+
+	cfg := newTestConfig(t, "http://authz")
+	cfg.AuthorizationService = &AuthzService{
+		Type: AuthzServiceTypeStatic,
+		PolicyFile: writeTestPolicy(t, `{
+			"rules": [
+				{
+					"subjects": ["user-1"],
+					"actions": ["test_get"],
+					"resources": ["urn:infratographer:test:`+resID.String()+`"]
+				}
+			]
+		}`),
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(subject string) RequestWrapper {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + testJWT(`{"sub":"`+subject+`"}`)}},
+			params:  map[string]string{"Test_id": resID.String()},
+		}
+	}
+
+	allowed, err := authorize(h, newReq("user-1"))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = authorize(h, newReq("user-2"))
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
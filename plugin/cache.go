@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// lruCache is a size-bounded, in-memory LRU cache of values which expire at a time set
+// for each entry. Expired entries are kept until they're evicted, so that they can still
+// be served by getStale.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type lruCacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// newLRUCache returns a new LRU cache holding at most size entries
+func newLRUCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// get returns the value of the given key and whether it was found and not expired at now
+func (c *lruCache[V]) get(key string, now time.Time) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := elem.Value.(*lruCacheEntry[V])
+	if !now.Before(entry.expires) {
+		return zero, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return entry.value, true
+}
+
+// getStale returns the value of the given key even if it has expired, as long as it
+// expired less than staleTTL before now
+func (c *lruCache[V]) getStale(key string, now time.Time, staleTTL time.Duration) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := elem.Value.(*lruCacheEntry[V])
+	if !now.Before(entry.expires.Add(staleTTL)) {
+		return zero, false
+	}
+
+	return entry.value, true
+}
+
+// set stores the value of the given key until it expires, evicting the least recently
+// used entry if the cache is full
+func (c *lruCache[V]) set(key string, value V, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*lruCacheEntry[V])
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+
+		return
+	}
+
+	for c.lru.Len() > 0 && c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruCacheEntry[V]).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&lruCacheEntry[V]{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+}
+
+// decisionCache is a size-bounded, in-memory LRU cache of authorization decisions.
+// Allow and deny decisions are kept for different amounts of time, so that a
+// permission being revoked or granted is picked up in a timely manner.
+// A nil *decisionCache is valid and behaves as a cache that never hits.
+type decisionCache struct {
+	entries  *lruCache[bool]
+	allowTTL time.Duration
+	denyTTL  time.Duration
+	staleTTL time.Duration
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// newDecisionCache returns a new decision cache for the given configuration.
+// It returns nil if caching is not configured.
+func newDecisionCache(cfg *CacheConfig) *decisionCache {
+	if cfg == nil || cfg.Size <= 0 {
+		return nil
+	}
+
+	return &decisionCache{
+		entries:  newLRUCache[bool](cfg.Size),
+		allowTTL: time.Duration(cfg.AllowTTL) * time.Millisecond,
+		denyTTL:  time.Duration(cfg.DenyTTL) * time.Millisecond,
+		staleTTL: time.Duration(cfg.StaleTTL) * time.Millisecond,
+		now:      time.Now,
+	}
+}
+
+// tokenCacheKey returns the hash of the given token, used in the keys of the caches so
+// that raw credentials are never kept in memory longer than needed
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// decisionCacheKey builds the cache key for the given token, action and resource URN
+func decisionCacheKey(token, action, urn string) string {
+	return tokenCacheKey(token) + "\x00" + action + "\x00" + urn
+}
+
+// get returns the cached decision for the given key and whether it was found
+func (c *decisionCache) get(key string) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+
+	return c.entries.get(key, c.now())
+}
+
+// getStale returns the cached decision for the given key even if it has expired,
+// as long as it expired less than the stale TTL ago. It is used as a last resort
+// when the authorization service is unavailable.
+func (c *decisionCache) getStale(key string) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+
+	return c.entries.getStale(key, c.now(), c.staleTTL)
+}
+
+// set stores the decision for the given key, evicting the least recently used
+// entry if the cache is full.
+func (c *decisionCache) set(key string, allowed bool) {
+	if c == nil {
+		return
+	}
+
+	ttl := c.denyTTL
+	if allowed {
+		ttl = c.allowTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	c.entries.set(key, allowed, c.now().Add(ttl))
+}
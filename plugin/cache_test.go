@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cache := newDecisionCache(&CacheConfig{
+		Size:     2,
+		AllowTTL: 1000,
+		DenyTTL:  100,
+	})
+	cache.now = func() time.Time { return now }
+
+	allowKey := decisionCacheKey("token", "read", "urn:infratographer:test:1")
+	denyKey := decisionCacheKey("token", "write", "urn:infratographer:test:1")
+
+	cache.set(allowKey, true)
+	cache.set(denyKey, false)
+
+	allowed, ok := cache.get(allowKey)
+	assert.True(t, ok, "expected allow decision to be cached")
+	assert.True(t, allowed)
+
+	allowed, ok = cache.get(denyKey)
+	assert.True(t, ok, "expected deny decision to be cached")
+	assert.False(t, allowed)
+
+	// deny decisions expire before allow decisions
+	now = now.Add(500 * time.Millisecond)
+
+	_, ok = cache.get(denyKey)
+	assert.False(t, ok, "expected deny decision to be expired")
+
+	_, ok = cache.get(allowKey)
+	assert.True(t, ok, "expected allow decision to still be cached")
+
+	now = now.Add(time.Second)
+
+	_, ok = cache.get(allowKey)
+	assert.False(t, ok, "expected allow decision to be expired")
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := newDecisionCache(&CacheConfig{
+		Size:     2,
+		AllowTTL: 1000,
+		DenyTTL:  1000,
+	})
+
+	cache.set("a", true)
+	cache.set("b", true)
+
+	// touch "a" so that "b" becomes the least recently used entry
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+
+	cache.set("c", true)
+
+	_, ok = cache.get("b")
+	assert.False(t, ok, "expected least recently used entry to be evicted")
+
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+
+	_, ok = cache.get("c")
+	assert.True(t, ok)
+}
+
+func TestDecisionCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	cache := newDecisionCache(nil)
+	assert.Nil(t, cache)
+
+	cache.set("a", true)
+
+	_, ok := cache.get("a")
+	assert.False(t, ok, "expected nil cache to never hit")
+
+	noDeny := newDecisionCache(&CacheConfig{Size: 10, AllowTTL: 1000})
+	noDeny.set("a", false)
+
+	_, ok = noDeny.get("a")
+	assert.False(t, ok, "expected deny decisions not to be cached with a zero TTL")
+}
+
+func TestDecisionCacheKey(t *testing.T) {
+	t.Parallel()
+
+	key := decisionCacheKey("Bearer secret", "read", "urn:infratographer:test:1")
+	assert.NotContains(t, key, "secret", "cache key should not contain the raw token")
+	assert.NotEqual(t, key, decisionCacheKey("Bearer other", "read", "urn:infratographer:test:1"))
+}
+
+func TestDecisionCacheGetStale(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cache := newDecisionCache(&CacheConfig{
+		Size:     2,
+		AllowTTL: 100,
+		DenyTTL:  100,
+		StaleTTL: 1000,
+	})
+	cache.now = func() time.Time { return now }
+
+	cache.set("a", true)
+
+	now = now.Add(500 * time.Millisecond)
+
+	_, ok := cache.get("a")
+	assert.False(t, ok, "expected decision to be expired")
+
+	allowed, ok := cache.getStale("a")
+	assert.True(t, ok, "expected expired decision to be served as stale")
+	assert.True(t, allowed)
+
+	now = now.Add(time.Second)
+
+	_, ok = cache.getStale("a")
+	assert.False(t, ok, "expected decision to be too stale")
+}
+
+func TestLRUCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cache := newLRUCache[string](2)
+
+	cache.set("a", "short-lived", now.Add(time.Second))
+	cache.set("b", "long-lived", now.Add(time.Minute))
+
+	value, ok := cache.get("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, "short-lived", value)
+
+	// entries expire independently
+	now = now.Add(2 * time.Second)
+
+	_, ok = cache.get("a", now)
+	assert.False(t, ok, "expected entry to be expired")
+
+	value, ok = cache.getStale("a", now, time.Minute)
+	assert.True(t, ok, "expected expired entry to be served as stale")
+	assert.Equal(t, "short-lived", value)
+
+	value, ok = cache.get("b", now)
+	assert.True(t, ok)
+	assert.Equal(t, "long-lived", value)
+
+	// "a" is the least recently used entry
+	cache.set("c", "new", now.Add(time.Minute))
+
+	_, ok = cache.getStale("a", now, time.Minute)
+	assert.False(t, ok, "expected least recently used entry to be evicted")
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker of the authorization service is open
+var ErrCircuitOpen = errors.New("authorization service circuit breaker is open")
+
+// circuitOpenError is returned instead of calling the authorization service while the
+// circuit breaker is open. It tells the caller when to retry.
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+// Error returns the error message
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrCircuitOpen, e.retryAfter)
+}
+
+// Is reports whether the target is ErrCircuitOpen
+func (e *circuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops calling the authorization service after a number of consecutive
+// failures. Once the open timeout elapses, a single probe request is let through
+// (half-open state): its success closes the circuit again, its failure re-opens it.
+type circuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// circuitBreakers holds the circuit breakers shared by every endpoint using the same
+// authorization service endpoint.
+var circuitBreakers = newRegistry[*circuitBreaker]("circuit breaker")
+
+// newCircuitBreaker returns a new circuit breaker for the given configuration
+func newCircuitBreaker(cfg *CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: cfg.FailureThreshold,
+		openTimeout:      time.Duration(cfg.OpenTimeout) * time.Millisecond,
+		now:              time.Now,
+	}
+}
+
+// getCircuitBreaker returns the circuit breaker shared by the given authorization service
+// endpoint, creating it if needed. The settings of the first configuration seen for an
+// endpoint are used. It returns nil if the circuit breaker is not configured.
+func getCircuitBreaker(svc *AuthzService) *circuitBreaker {
+	if svc.CircuitBreaker == nil {
+		return nil
+	}
+
+	cb, _ := circuitBreakers.get(svc.Endpoint.String(), *svc.CircuitBreaker, func() (*circuitBreaker, error) {
+		return newCircuitBreaker(svc.CircuitBreaker), nil
+	})
+
+	return cb
+}
+
+// allow returns nil if a call to the authorization service may be performed, or a
+// *circuitOpenError if the circuit is open.
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		elapsed := cb.now().Sub(cb.openedAt)
+		if elapsed < cb.openTimeout {
+			return &circuitOpenError{retryAfter: cb.openTimeout - elapsed}
+		}
+
+		cb.state = circuitHalfOpen
+		cb.probing = true
+
+		return nil
+	case circuitHalfOpen:
+		// only a single probe is let through while half-open
+		if cb.probing {
+			return &circuitOpenError{retryAfter: cb.openTimeout}
+		}
+
+		cb.probing = true
+
+		return nil
+	default:
+		return nil
+	}
+}
+
+// success records a successful call to the authorization service
+func (cb *circuitBreaker) success() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != circuitClosed {
+		logger.Info("authorization service recovered, closing circuit breaker")
+	}
+
+	cb.state = circuitClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// failure records a failed call to the authorization service
+func (cb *circuitBreaker) failure() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.probing = false
+
+	if cb.state == circuitHalfOpen || cb.failures >= cb.failureThreshold {
+		if cb.state != circuitOpen {
+			logger.Error("authorization service failing, opening circuit breaker", "consecutive_failures", cb.failures)
+		}
+
+		cb.state = circuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// release records a call to the authorization service that neither succeeded nor failed, such
+// as a call rejected because of the request or abandoned before completing. It lets another
+// probe through when half-open.
+func (cb *circuitBreaker) release() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cb := newCircuitBreaker(&CircuitBreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      1000,
+	})
+	cb.now = func() time.Time { return now }
+
+	// failures below the threshold keep the circuit closed
+	for i := 0; i < 2; i++ {
+		require.NoError(t, cb.allow())
+		cb.failure()
+	}
+
+	// a success resets the consecutive failures
+	require.NoError(t, cb.allow())
+	cb.success()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, cb.allow())
+		cb.failure()
+	}
+
+	err := cb.allow()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	var cbErr *circuitOpenError
+	require.ErrorAs(t, err, &cbErr)
+	assert.Equal(t, time.Second, cbErr.retryAfter)
+
+	// after the open timeout a single probe is let through
+	now = now.Add(time.Second)
+
+	require.NoError(t, cb.allow(), "expected probe to be allowed")
+	assert.ErrorIs(t, cb.allow(), ErrCircuitOpen, "expected only a single probe while half-open")
+
+	// a failed probe re-opens the circuit
+	cb.failure()
+	assert.ErrorIs(t, cb.allow(), ErrCircuitOpen)
+
+	now = now.Add(time.Second)
+
+	// a successful probe closes the circuit
+	require.NoError(t, cb.allow())
+	cb.success()
+
+	require.NoError(t, cb.allow())
+	require.NoError(t, cb.allow())
+}
+
+func TestGetCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, getCircuitBreaker(&AuthzService{Endpoint: mustParseURL(t, "http://authz-no-cb")}))
+
+	svc := &AuthzService{
+		Endpoint: mustParseURL(t, "http://authz-shared-cb"),
+		CircuitBreaker: &CircuitBreakerConfig{
+			FailureThreshold: 1,
+			OpenTimeout:      1000,
+		},
+	}
+
+	cb := getCircuitBreaker(svc)
+	require.NotNil(t, cb)
+	assert.Same(t, cb, getCircuitBreaker(svc), "expected endpoints using the same authz service to share a circuit breaker")
+
+	other := getCircuitBreaker(&AuthzService{
+		Endpoint:       mustParseURL(t, "http://authz-other-cb"),
+		CircuitBreaker: svc.CircuitBreaker,
+	})
+	assert.NotSame(t, cb, other)
+}
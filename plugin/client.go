@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tokenContextKey is the context key holding the bearer token of the request being authorized
+type tokenContextKey struct{}
+
+// contextWithToken returns a copy of the context carrying the given bearer token
+func contextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// tokenFromContext returns the bearer token carried by the context, if any
+func tokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenContextKey{}).(string)
+
+	return token
+}
+
+// tokenRoundTripper is a round tripper that adds the authorization token to the request
+// It is used to create the authz client.
+// The token is taken from the request context, which allows a single pooled client to
+// be shared by every request handled for an endpoint.
+// Note that token validation is not performed here, it is performed by an earlier
+// plugin in the API Gateway, as well as the authorization service.
+// Note that this simple round tripper was created to avoid conflicting
+// dependencies with https://pkg.go.dev/golang.org/x/oauth2 which is used by the
+// krakend plugin builder.
+type tokenRoundTripper struct {
+	trans http.RoundTripper
+}
+
+func newTokenRoundTripper(trans http.RoundTripper) *tokenRoundTripper {
+	return &tokenRoundTripper{
+		trans: trans,
+	}
+}
+
+// RoundTrip adds the authorization token found in the request context to the request
+// and calls the underlying transport to perform the request.
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := tokenFromContext(req.Context())
+	if token == "" {
+		return t.trans.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the given request
+	req = req.Clone(req.Context())
+	req.Header.Set(AuthorizationHeader, token)
+
+	return t.trans.RoundTrip(req)
+}
+
+// newAuthzHTTPClient returns the pooled HTTP client used to talk to the authorization service
+func newAuthzHTTPClient(svc *AuthzService) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(svc.DialTimeout) * time.Millisecond,
+		KeepAlive: 30 * time.Second,
+	}
+
+	trans := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          svc.MaxIdleConns,
+		MaxIdleConnsPerHost:   svc.MaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(svc.IdleConnTimeout) * time.Millisecond,
+		TLSHandshakeTimeout:   time.Duration(svc.TLSHandshakeTimeout) * time.Millisecond,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Transport: otelhttp.NewTransport(newTokenRoundTripper(trans), otelhttp.WithPropagators(tracePropagator)),
+	}
+}
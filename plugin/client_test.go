@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	var gotTokens []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTokens = append(gotTokens, r.Header.Get(AuthorizationHeader))
+	}))
+	defer srv.Close()
+
+	cli := newAuthzHTTPClient(&AuthzService{
+		MaxIdleConns:        1,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     1000,
+		DialTimeout:         1000,
+		TLSHandshakeTimeout: 1000,
+	})
+
+	for _, token := range []string{"Bearer one", "Bearer two", ""} {
+		req, err := http.NewRequestWithContext(contextWithToken(context.Background(), token), http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := cli.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Empty(t, req.Header.Get(AuthorizationHeader), "round tripper should not modify the original request")
+	}
+
+	assert.Equal(t, []string{"Bearer one", "Bearer two", ""}, gotTokens)
+}
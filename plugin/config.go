@@ -3,7 +3,15 @@ package plugin
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.infratographer.com/x/urnx"
 )
 
 const (
@@ -11,14 +19,280 @@ const (
 	AuthzServiceKey = "authz_service"
 	// AuthnServiceEndpointKey is the key used to retrieve the authorization server endpoint from the configuration
 	AuthnServiceEndpointKey = "endpoint"
+	// AuthzServiceTypeKey is the key used to retrieve the type of the authorization service
+	AuthzServiceTypeKey = "type"
+	// AuthzServicePolicyFileKey is the key used to retrieve the path of the policy file of the
+	// static authorization service
+	AuthzServicePolicyFileKey = "policy_file"
+	// AuthzServicePolicyReloadIntervalKey is the key used to retrieve how often the policy file
+	// of the static authorization service is checked for changes
+	AuthzServicePolicyReloadIntervalKey = "policy_reload_interval"
+	// AuthzServiceAllowedHeadersKey is the key used to retrieve the request headers sent to the
+	// ext_authz authorization service
+	AuthzServiceAllowedHeadersKey = "allowed_headers"
+	// AuthzServiceAllowedUpstreamHeadersKey is the key used to retrieve the headers of the
+	// ext_authz responses added to the request sent to the backend
+	AuthzServiceAllowedUpstreamHeadersKey = "allowed_upstream_headers"
+	// AuthzServiceSubjectClaimKey is the key used to retrieve the token claim holding the subject
+	// of the static authorization service
+	AuthzServiceSubjectClaimKey = "subject_claim"
 	// AuthnServiceTimeoutKey is the key used to retrieve the authorization server timeout from the configuration
 	AuthnServiceTimeoutKey = "timeout"
+	// AuthzServiceMaxIdleConnsKey is the key used to retrieve the maximum number of idle connections
+	AuthzServiceMaxIdleConnsKey = "max_idle_conns"
+	// AuthzServiceMaxIdleConnsPerHostKey is the key used to retrieve the maximum number of idle connections per host
+	AuthzServiceMaxIdleConnsPerHostKey = "max_idle_conns_per_host"
+	// AuthzServiceIdleConnTimeoutKey is the key used to retrieve the idle connection timeout
+	AuthzServiceIdleConnTimeoutKey = "idle_conn_timeout"
+	// AuthzServiceDialTimeoutKey is the key used to retrieve the dial timeout
+	AuthzServiceDialTimeoutKey = "dial_timeout"
+	// AuthzServiceTLSHandshakeTimeoutKey is the key used to retrieve the TLS handshake timeout
+	AuthzServiceTLSHandshakeTimeoutKey = "tls_handshake_timeout"
+	// AuthzServiceCircuitBreakerKey is the key used to retrieve the circuit breaker configuration
+	AuthzServiceCircuitBreakerKey = "circuit_breaker"
+	// CircuitBreakerFailureThresholdKey is the key used to retrieve the number of consecutive
+	// failures opening the circuit breaker
+	CircuitBreakerFailureThresholdKey = "failure_threshold"
+	// CircuitBreakerOpenTimeoutKey is the key used to retrieve how long the circuit breaker stays open
+	CircuitBreakerOpenTimeoutKey = "open_timeout"
 	// ActionKey is the key used to retrieve the action from the configuration
 	ActionKey = "action"
+	// ActionsKey is the key used to retrieve the HTTP method to action mapping from the configuration
+	ActionsKey = "actions"
 	// ResourceTypeKey is the key used to retrieve the resource type from the configuration
 	ResourceTypeKey = "resource_type"
 	// ResourceParamKey is the key used to retrieve the resource param from the configuration
 	ResourceParamKey = "resource_param"
+	// ResourceSourceKey is the key used to retrieve the resource source from the configuration
+	ResourceSourceKey = "resource_source"
+	// ResourceSourceTypeKey is the key used to retrieve the type of the resource source
+	ResourceSourceTypeKey = "type"
+	// ResourceSourceNameKey is the key used to retrieve the name of the param, query param,
+	// header or the JSON pointer the resource ID is read from
+	ResourceSourceNameKey = "name"
+	// ResourceSourceMaxBodySizeKey is the key used to retrieve the maximum request body size
+	ResourceSourceMaxBodySizeKey = "max_body_size"
+	// CreateKey is the key used to retrieve the create mode settings of a check
+	CreateKey = "create"
+	// CreateParentResourceTypeKey is the key used to retrieve the resource type of the parent
+	// resource checked in create mode
+	CreateParentResourceTypeKey = "parent_resource_type"
+	// ChecksKey is the key used to retrieve the list of permission checks from the configuration
+	ChecksKey = "checks"
+	// ChecksModeKey is the key used to retrieve how the results of the checks are combined
+	ChecksModeKey = "checks_mode"
+	// URNNamespaceKey is the key used to retrieve the URN namespace from the configuration
+	URNNamespaceKey = "urn_namespace"
+	// URNCompatNamespaceKey is the key used to retrieve the URN namespace which is also
+	// checked while migrating from one namespace to another
+	URNCompatNamespaceKey = "urn_compat_namespace"
+	// ResourceIDFormatsKey is the key used to retrieve the accepted resource ID formats
+	ResourceIDFormatsKey = "resource_id_formats"
+	// ResourceIDPrefixesKey is the key used to retrieve the mapping of ID prefixes to resource types
+	ResourceIDPrefixesKey = "resource_id_prefixes"
+	// CacheKey is the key used to retrieve the decision cache configuration
+	CacheKey = "cache"
+	// CacheSizeKey is the key used to retrieve the maximum number of cached decisions
+	CacheSizeKey = "size"
+	// CacheAllowTTLKey is the key used to retrieve the TTL of allow decisions
+	CacheAllowTTLKey = "allow_ttl"
+	// CacheDenyTTLKey is the key used to retrieve the TTL of deny decisions
+	CacheDenyTTLKey = "deny_ttl"
+	// CacheStaleTTLKey is the key used to retrieve for how long expired decisions may still be used
+	CacheStaleTTLKey = "stale_ttl"
+	// InvalidResourceStatusKey is the key used to retrieve the HTTP status code returned
+	// when the resource ID is missing or invalid
+	InvalidResourceStatusKey = "invalid_resource_status"
+	// ErrorMessagesKey is the key used to retrieve the custom error messages, keyed by error code
+	ErrorMessagesKey = "error_messages"
+	// RequestIDHeaderKey is the key used to retrieve the name of the header holding the request ID
+	RequestIDHeaderKey = "request_id_header"
+	// OnAuthzErrorKey is the key used to retrieve the policy applied when the authorization service fails
+	OnAuthzErrorKey = "on_authz_error"
+	// ModeKey is the key used to retrieve the enforcement mode from the configuration
+	ModeKey = "mode"
+	// EnforcePercentKey is the key used to retrieve the percentage of callers for which
+	// decisions are enforced
+	EnforcePercentKey = "enforce_percent"
+	// EnforcePercentByKey is the key used to retrieve what callers are bucketed by for enforce_percent
+	EnforcePercentByKey = "enforce_percent_by"
+	// AuditKey is the key used to retrieve the audit log configuration
+	AuditKey = "audit"
+	// AuditSinksKey is the key used to retrieve the list of audit log sinks
+	AuditSinksKey = "sinks"
+	// AuditSinkTypeKey is the key used to retrieve the type of an audit log sink
+	AuditSinkTypeKey = "type"
+	// AuditSinkPathKey is the key used to retrieve the path of the file audit log sink
+	AuditSinkPathKey = "path"
+	// AuditSinkMaxSizeKey is the key used to retrieve the size in bytes the audit log file is rotated at
+	AuditSinkMaxSizeKey = "max_size"
+	// AuditSinkMaxBackupsKey is the key used to retrieve the number of rotated audit log files kept
+	AuditSinkMaxBackupsKey = "max_backups"
+	// AuditSinkURLKey is the key used to retrieve the URL of the webhook audit log sink
+	AuditSinkURLKey = "url"
+	// AuditSinkTimeoutKey is the key used to retrieve the timeout of the webhook calls
+	AuditSinkTimeoutKey = "timeout"
+	// AuditSinkBufferSizeKey is the key used to retrieve the number of audit events queued
+	// for the webhook
+	AuditSinkBufferSizeKey = "buffer_size"
+	// EndpointNameKey is the key used to retrieve the name of the endpoint
+	EndpointNameKey = "endpoint_name"
+	// MetricsKey is the key used to retrieve the metrics listener configuration
+	MetricsKey = "metrics"
+	// MetricsListenAddressKey is the key used to retrieve the address the metrics are served on
+	MetricsListenAddressKey = "listen_address"
+	// MetricsPathKey is the key used to retrieve the HTTP path the metrics are served on
+	MetricsPathKey = "path"
+	// LogLevelKey is the key used to retrieve the minimum level of the messages logged for the endpoint
+	LogLevelKey = "log_level"
+	// LogAllowSampleKey is the key used to retrieve the sampling rate of the allowed requests logs
+	LogAllowSampleKey = "log_allow_sample"
+	// JWTKey is the key used to retrieve the JWT verification configuration
+	JWTKey = "jwt"
+	// JWTJWKSURLKey is the key used to retrieve the URL of the JWKS holding the token signing keys
+	JWTJWKSURLKey = "jwks_url"
+	// JWTIssuerKey is the key used to retrieve the expected issuer of the tokens
+	JWTIssuerKey = "issuer"
+	// JWTAudiencesKey is the key used to retrieve the accepted audiences of the tokens
+	JWTAudiencesKey = "audiences"
+	// JWTAlgorithmsKey is the key used to retrieve the accepted signing algorithms
+	JWTAlgorithmsKey = "algorithms"
+	// JWTClockSkewKey is the key used to retrieve the clock skew tolerated when checking
+	// the validity period of the tokens
+	JWTClockSkewKey = "clock_skew"
+	// JWTJWKSCacheTTLKey is the key used to retrieve how long the JWKS is cached
+	JWTJWKSCacheTTLKey = "jwks_cache_ttl"
+	// JWTJWKSTimeoutKey is the key used to retrieve the timeout of the JWKS requests
+	JWTJWKSTimeoutKey = "jwks_timeout"
+	// IntrospectionKey is the key used to retrieve the token introspection configuration
+	IntrospectionKey = "introspection"
+	// IntrospectionEndpointKey is the key used to retrieve the URL of the introspection endpoint
+	IntrospectionEndpointKey = "endpoint"
+	// IntrospectionClientIDKey is the key used to retrieve the client ID porton authenticates with
+	IntrospectionClientIDKey = "client_id"
+	// IntrospectionClientSecretKey is the key used to retrieve the client secret porton authenticates with
+	IntrospectionClientSecretKey = "client_secret"
+	// IntrospectionTimeoutKey is the key used to retrieve the timeout of the introspection requests
+	IntrospectionTimeoutKey = "timeout"
+	// IntrospectionCacheTTLKey is the key used to retrieve how long introspection results are cached
+	IntrospectionCacheTTLKey = "cache_ttl"
+	// IntrospectionCacheSizeKey is the key used to retrieve the maximum number of cached introspection results
+	IntrospectionCacheSizeKey = "cache_size"
+	// RequiredScopesKey is the key used to retrieve the scopes a token must have been granted
+	RequiredScopesKey = "required_scopes"
+	// InjectHeadersKey is the key used to retrieve the headers injected into allowed requests
+	InjectHeadersKey = "inject_headers"
+	// InjectHeadersSubjectKey is the key used to retrieve the name of the subject header
+	InjectHeadersSubjectKey = "subject"
+	// InjectHeadersResourceURNKey is the key used to retrieve the name of the resource URN header
+	InjectHeadersResourceURNKey = "resource_urn"
+	// InjectHeadersActionKey is the key used to retrieve the name of the action header
+	InjectHeadersActionKey = "action"
+)
+
+const (
+	// AuthzServiceTypePermissionsAPI takes decisions with the permissions-api
+	AuthzServiceTypePermissionsAPI = "permissions-api"
+	// AuthzServiceTypeStatic takes decisions with a static policy file
+	AuthzServiceTypeStatic = "static"
+	// AuthzServiceTypeHTTP takes decisions with a generic HTTP JSON decision endpoint
+	AuthzServiceTypeHTTP = "http"
+	// AuthzServiceTypeExtAuthz takes decisions with a service speaking the HTTP protocol of
+	// Envoy's ext_authz filter
+	AuthzServiceTypeExtAuthz = "ext_authz"
+
+	// DefaultSubjectClaim is the default token claim holding the subject of the static authorization service
+	DefaultSubjectClaim = "sub"
+)
+
+const (
+	// DefaultSubjectHeader is the default header carrying the subject of allowed requests
+	DefaultSubjectHeader = "X-Infratographer-Subject"
+	// DefaultResourceURNHeader is the default header carrying the resource URNs of allowed requests
+	DefaultResourceURNHeader = "X-Infratographer-Resource-URN"
+	// DefaultActionHeader is the default header carrying the actions of allowed requests
+	DefaultActionHeader = "X-Infratographer-Action"
+)
+
+const (
+	// AuditSinkLogger writes audit events to the KrakenD logger
+	AuditSinkLogger = "logger"
+	// AuditSinkFile appends audit events to a JSON lines file
+	AuditSinkFile = "file"
+	// AuditSinkWebhook posts audit events to an HTTP endpoint
+	AuditSinkWebhook = "webhook"
+)
+
+const (
+	// ModeEnvVar is the environment variable holding the enforcement mode of endpoints
+	// which do not configure one
+	ModeEnvVar = "PORTON_MODE"
+	// ModeEnforce rejects the requests which are not allowed
+	ModeEnforce = "enforce"
+	// ModeShadow checks the requests and records the decisions, but lets every request through
+	ModeShadow = "shadow"
+)
+
+const (
+	// EnforcePercentBySubject buckets callers by the verified subject of their token
+	EnforcePercentBySubject = "subject"
+	// EnforcePercentByRandom buckets each request at random
+	EnforcePercentByRandom = "random"
+)
+
+const (
+	// DefaultURNNamespace is the URN namespace used when none is configured. It keeps the
+	// spelling of the namespace hard-coded by earlier porton releases, so that permissions
+	// granted under it keep matching.
+	DefaultURNNamespace = "infratrographer"
+)
+
+const (
+	// DefaultRequestIDHeader is the header the request ID is read from when none is configured
+	DefaultRequestIDHeader = "X-Request-Id"
+)
+
+const (
+	// ChecksModeAll allows a request only if every check allows it
+	ChecksModeAll = "all"
+	// ChecksModeAny allows a request if at least one check allows it
+	ChecksModeAny = "any"
+)
+
+const (
+	// OnAuthzErrorDeny denies the request when the authorization service fails
+	OnAuthzErrorDeny = "deny"
+	// OnAuthzErrorAllow allows the request when the authorization service fails
+	OnAuthzErrorAllow = "allow"
+	// OnAuthzErrorAllowStale uses the last cached decision when the authorization service fails,
+	// and denies the request if there is none
+	OnAuthzErrorAllowStale = "allow_stale"
+)
+
+const (
+	defaultAuthzTimeout  = 1000
+	defaultMaxIdleConns  = 100
+	defaultIdleTimeout   = 90000
+	defaultDialTimeout   = 30000
+	defaultTLSTimeout    = 10000
+	defaultMaxBodySize   = 1 << 20
+	defaultCBThreshold   = 5
+	defaultCBOpenTimeout = 10000
+	defaultCacheSize     = 10000
+	defaultCacheAllowTTL = 30000
+	defaultCacheDenyTTL  = 5000
+	defaultCacheStaleTTL = 300000
+	defaultAuditMaxSize  = 100 << 20
+	defaultAuditBackups  = 5
+	defaultAuditTimeout  = 5000
+	defaultAuditBuffer   = 1000
+	defaultJWTClockSkew  = 60000
+	defaultJWKSCacheTTL  = 900000
+	defaultJWKSTimeout   = 5000
+	defaultIntroTimeout  = 5000
+	defaultIntroCacheTTL = 60000
+	defaultIntroCache    = 10000
+	defaultPolicyReload  = 5000
 )
 
 var (
@@ -29,22 +303,324 @@ var (
 )
 
 type AuthzService struct {
-	// Endpoint is the URL of the authorization server
-	Endpoint *url.URL `json:"endpoint"`
+	// Type is the type of the authorization service: permissions-api, static, http or ext_authz
+	// defaults to permissions-api
+	Type string `json:"type"`
+	// Endpoint is the URL of the authorization server, it's not set for the static type
+	Endpoint *url.URL `json:"endpoint,omitempty"`
+	// PolicyFile is the path of the policy file of the static type
+	PolicyFile string `json:"policy_file,omitempty"`
+	// PolicyReloadInterval is how often the policy file is checked for changes in milliseconds,
+	// it's never reloaded when 0
+	// defaults to 5000 for the static type
+	PolicyReloadInterval int `json:"policy_reload_interval,omitempty"`
+	// SubjectClaim is the token claim holding the subject matched against the policy file
+	// defaults to sub for the static type
+	SubjectClaim string `json:"subject_claim,omitempty"`
+	// AllowedHeaders are the request headers sent to the ext_authz authorization service,
+	// along with the Authorization header
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	// AllowedUpstreamHeaders are the headers of the ext_authz responses allowing a request
+	// which are added to the request sent to the backend
+	AllowedUpstreamHeaders []string `json:"allowed_upstream_headers,omitempty"`
 	// Timeout is the timeout for the authorization server in milliseconds
 	// defaults to 1000
 	Timeout int `json:"timeout"`
+	// MaxIdleConns is the maximum number of idle connections kept to the authorization server
+	// defaults to 100
+	MaxIdleConns int `json:"max_idle_conns"`
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept per host
+	// defaults to 100
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+	// IdleConnTimeout is how long an idle connection is kept open in milliseconds
+	// defaults to 90000
+	IdleConnTimeout int `json:"idle_conn_timeout"`
+	// DialTimeout is the timeout for establishing a connection in milliseconds
+	// defaults to 30000
+	DialTimeout int `json:"dial_timeout"`
+	// TLSHandshakeTimeout is the timeout for the TLS handshake in milliseconds
+	// defaults to 10000
+	TLSHandshakeTimeout int `json:"tls_handshake_timeout"`
+	// CircuitBreaker holds the circuit breaker settings, the circuit breaker is disabled when nil
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
 }
 
-type Config struct {
-	// AuthorizationService is the URL of the authorization server
-	AuthorizationService *AuthzService `json:"authz_service"`
-	// Action is the action to be performed
+// CircuitBreakerConfig holds the settings of the circuit breaker around the authorization service
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures after which the circuit opens
+	// defaults to 5
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenTimeout is how long the circuit stays open before a probe request is let through,
+	// in milliseconds
+	// defaults to 10000
+	OpenTimeout int `json:"open_timeout"`
+}
+
+// CacheConfig holds the settings of the authorization decision cache
+type CacheConfig struct {
+	// Size is the maximum number of decisions kept in the cache
+	// defaults to 10000
+	Size int `json:"size"`
+	// AllowTTL is how long allow decisions are cached in milliseconds
+	// defaults to 30000
+	AllowTTL int `json:"allow_ttl"`
+	// DenyTTL is how long deny decisions are cached in milliseconds.
+	// A value of 0 disables caching of deny decisions.
+	// defaults to 5000
+	DenyTTL int `json:"deny_ttl"`
+	// StaleTTL is for how long after expiring a decision may still be used by
+	// the allow_stale policy, in milliseconds
+	// defaults to 300000
+	StaleTTL int `json:"stale_ttl"`
+}
+
+// InjectHeadersConfig holds the names of the headers porton adds to the requests it allows.
+// Copies of these headers sent by clients are always removed.
+type InjectHeadersConfig struct {
+	// Subject is the header carrying the subject of the token
+	// defaults to X-Infratographer-Subject
+	Subject string `json:"subject"`
+	// ResourceURN is the header carrying the URNs of the resources checked
+	// defaults to X-Infratographer-Resource-URN
+	ResourceURN string `json:"resource_urn"`
+	// Action is the header carrying the actions checked
+	// defaults to X-Infratographer-Action
+	Action string `json:"action"`
+}
+
+// JWTConfig holds the settings of the verification of the JWTs by porton
+type JWTConfig struct {
+	// JWKSURL is the URL of the JWKS holding the token signing keys
+	JWKSURL *url.URL `json:"jwks_url"`
+	// Issuer is the expected issuer of the tokens
+	Issuer string `json:"issuer"`
+	// Audiences are the accepted audiences, a token must be issued for one of them.
+	// The audience is not checked when empty.
+	Audiences []string `json:"audiences,omitempty"`
+	// Algorithms are the accepted signing algorithms
+	// defaults to RS256
+	Algorithms []string `json:"algorithms"`
+	// ClockSkew is the clock skew tolerated when checking the validity period of the
+	// tokens in milliseconds
+	// defaults to 60000
+	ClockSkew int `json:"clock_skew"`
+	// JWKSCacheTTL is how long the JWKS is cached in milliseconds. It's fetched again
+	// before then when a token is signed by an unknown key.
+	// defaults to 900000
+	JWKSCacheTTL int `json:"jwks_cache_ttl"`
+	// JWKSTimeout is the timeout of the JWKS requests in milliseconds
+	// defaults to 5000
+	JWKSTimeout int `json:"jwks_timeout"`
+}
+
+// IntrospectionConfig holds the settings of the OAuth2 token introspection (RFC 7662) by porton
+type IntrospectionConfig struct {
+	// Endpoint is the URL of the introspection endpoint
+	Endpoint *url.URL `json:"endpoint"`
+	// ClientID is the client ID porton authenticates to the introspection endpoint with
+	ClientID string `json:"client_id"`
+	// ClientSecret is the client secret porton authenticates to the introspection endpoint with
+	ClientSecret string `json:"-"`
+	// Timeout is the timeout of the introspection requests in milliseconds
+	// defaults to 5000
+	Timeout int `json:"timeout"`
+	// CacheTTL is how long the results of active tokens are cached in milliseconds, and never
+	// beyond the expiry of the token. Results are not cached when 0.
+	// defaults to 60000
+	CacheTTL int `json:"cache_ttl"`
+	// CacheSize is the maximum number of cached results
+	// defaults to 10000
+	CacheSize int `json:"cache_size"`
+}
+
+// MetricsConfig holds the settings of the Prometheus metrics listener
+type MetricsConfig struct {
+	// ListenAddress is the address the metrics are served on, e.g. 127.0.0.1:9091
+	ListenAddress string `json:"listen_address"`
+	// Path is the HTTP path the metrics are served on
+	// defaults to /metrics
+	Path string `json:"path"`
+}
+
+// AuditConfig holds the settings of the audit log
+type AuditConfig struct {
+	// Sinks are where the audit events are written to
+	Sinks []*AuditSinkConfig `json:"sinks"`
+}
+
+// AuditSinkConfig holds the settings of an audit log sink
+type AuditSinkConfig struct {
+	// Type is the type of the sink, one of logger, file or webhook
+	Type string `json:"type"`
+	// Path is the path of the audit log file, only used by the file type
+	Path string `json:"path,omitempty"`
+	// MaxSize is the size in bytes the audit log file is rotated at, only used by the file type
+	// defaults to 104857600
+	MaxSize int `json:"max_size,omitempty"`
+	// MaxBackups is the number of rotated audit log files kept, only used by the file type
+	// defaults to 5
+	MaxBackups int `json:"max_backups,omitempty"`
+	// URL is the URL the audit events are posted to, only used by the webhook type
+	URL *url.URL `json:"url,omitempty"`
+	// Timeout is the timeout of the webhook calls in milliseconds, only used by the webhook type
+	// defaults to 5000
+	Timeout int `json:"timeout,omitempty"`
+	// BufferSize is the number of audit events queued for the webhook, events are dropped
+	// when the queue is full. Only used by the webhook type.
+	// defaults to 1000
+	BufferSize int `json:"buffer_size,omitempty"`
+}
+
+// ResourceSource describes where in the request the resource ID is read from
+type ResourceSource struct {
+	// Type is the location of the resource ID, one of param, query, header or body
+	Type string `json:"type"`
+	// Name is the name of the path param, query param or header holding the resource ID.
+	// For the body type it is a JSON pointer into the JSON request body.
+	Name string `json:"name"`
+	// MaxBodySize is the maximum size of the request body in bytes, only used by the body type
+	// defaults to 1048576
+	MaxBodySize int `json:"max_body_size,omitempty"`
+}
+
+// CreateCheck holds the create mode settings of a check. In create mode the resource does
+// not exist yet, so the create action is checked against its parent resource instead.
+type CreateCheck struct {
+	// ParentResourceType is the resource type of the parent resource, e.g. tenant
+	ParentResourceType string `json:"parent_resource_type"`
+	// Action is the action checked against the parent resource
+	// defaults to <resource_type>_create
 	Action string `json:"action"`
+}
+
+// Check is a single permission check performed for each request: the action
+// checked against the resource found in the request.
+type Check struct {
+	// Action is the action to be performed. When Actions is set, it is used as
+	// the fallback for HTTP methods that are not mapped.
+	Action string `json:"action,omitempty"`
+	// Actions maps HTTP methods to the action to be performed
+	Actions map[string]string `json:"actions,omitempty"`
 	// ResourceType is the name of resource type
 	ResourceType string `json:"resource_type"`
+	// ResourceIDFormats are the accepted formats of the resource ID, tried in order.
+	// defaults to uuid
+	ResourceIDFormats []string `json:"resource_id_formats"`
+	// ResourceIDPrefixes maps the prefixes of prefixed IDs to their resource type
+	ResourceIDPrefixes map[string]string `json:"resource_id_prefixes,omitempty"`
 	// ResourceParam is the name of the resource parameter
-	ResourceParam string `json:"resource_param"`
+	ResourceParam string `json:"resource_param,omitempty"`
+	// ResourceSource describes where the resource ID is read from. When only
+	// ResourceParam is configured, it points to that path parameter.
+	ResourceSource *ResourceSource `json:"resource_source"`
+	// Create enables create mode, in which the resource ID found in the request is
+	// the ID of the parent resource
+	Create *CreateCheck `json:"create,omitempty"`
+}
+
+type Config struct {
+	// AuthorizationService is the URL of the authorization server
+	AuthorizationService *AuthzService `json:"authz_service"`
+	// Checks are the permission checks performed for each request. A configuration
+	// with the check settings at the top level results in a single check.
+	Checks []*Check `json:"checks"`
+	// ChecksMode is how the results of the checks are combined, either all or any
+	// defaults to all
+	ChecksMode string `json:"checks_mode"`
+	// URNNamespace is the namespace of the resource URNs checked against the authorization server
+	URNNamespace string `json:"urn_namespace"`
+	// URNCompatNamespace is an optional second namespace. When set, a request denied under
+	// URNNamespace is also checked under this namespace, which allows migrating between
+	// namespaces without downtime.
+	URNCompatNamespace string `json:"urn_compat_namespace,omitempty"`
+	// Cache holds the decision cache settings, caching is disabled when nil
+	Cache *CacheConfig `json:"cache,omitempty"`
+	// OnAuthzError is the policy applied when the authorization service fails,
+	// one of deny, allow or allow_stale
+	// defaults to deny
+	OnAuthzError string `json:"on_authz_error"`
+	// InvalidResourceStatus is the HTTP status code returned when the resource ID
+	// is missing or invalid, either 400 or 404
+	// defaults to 400
+	InvalidResourceStatus int `json:"invalid_resource_status"`
+	// ErrorMessages overrides the detail of the error responses, keyed by error code
+	ErrorMessages map[string]string `json:"error_messages,omitempty"`
+	// RequestIDHeader is the name of the header holding the request ID
+	// defaults to X-Request-Id
+	RequestIDHeader string `json:"request_id_header"`
+	// Mode is the enforcement mode, either enforce or shadow
+	// defaults to the PORTON_MODE environment variable, or enforce if it is not set
+	Mode string `json:"mode"`
+	// EnforcePercent is the percentage of callers for which decisions are enforced in enforce
+	// mode, the requests of the other callers are handled as in shadow mode
+	// defaults to 100
+	EnforcePercent int `json:"enforce_percent"`
+	// EnforcePercentBy is what callers are bucketed by for EnforcePercent, either subject or random
+	// defaults to subject
+	EnforcePercentBy string `json:"enforce_percent_by"`
+	// Audit holds the audit log settings, the audit log is disabled when nil
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// EndpointName is the name of the endpoint in the metrics and the audit log
+	EndpointName string `json:"endpoint_name,omitempty"`
+	// Metrics holds the metrics listener settings, the metrics are not served when nil
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+	// LogLevel is the minimum level of the messages logged for the endpoint,
+	// one of debug, info, warn or error
+	// defaults to info
+	LogLevel string `json:"log_level"`
+	// LogAllowSample is the sampling rate of the allowed requests logs: one of every
+	// LogAllowSample allowed requests is logged
+	// defaults to 1
+	LogAllowSample int `json:"log_allow_sample"`
+	// JWT holds the JWT verification settings, tokens are not verified by porton when nil
+	JWT *JWTConfig `json:"jwt,omitempty"`
+	// Introspection holds the token introspection settings, tokens are not introspected
+	// by porton when nil
+	Introspection *IntrospectionConfig `json:"introspection,omitempty"`
+	// RequiredScopes are the scopes a token must have been granted before the authorization
+	// service is called, no scope is required when empty
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	// InjectHeaders holds the headers added to allowed requests, no header is added when nil
+	InjectHeaders *InjectHeadersConfig `json:"inject_headers,omitempty"`
+}
+
+// actionFor returns the action to check for the given HTTP method.
+// Methods without a mapping fall back to Action. An empty string is returned when
+// there is no action for the method, in which case the request must be denied.
+func (c *Check) actionFor(method string) string {
+	if c.Create != nil {
+		return c.Create.Action
+	}
+
+	if action, ok := c.Actions[strings.ToUpper(method)]; ok {
+		return action
+	}
+
+	return c.Action
+}
+
+// urnResourceType returns the resource type of the resource found in the request,
+// which in create mode is the type of the parent resource.
+func (c *Check) urnResourceType() string {
+	if c.Create != nil {
+		return c.Create.ParentResourceType
+	}
+
+	return c.ResourceType
+}
+
+// maxBodySize returns the largest request body size accepted by the checks reading
+// the resource ID from the body, and whether any check does.
+func (c *Config) maxBodySize() (int, bool) {
+	size, found := 0, false
+
+	for _, check := range c.Checks {
+		if check.ResourceSource.Type == ResourceSourceBody && check.ResourceSource.MaxBodySize > size {
+			size, found = check.ResourceSource.MaxBodySize, true
+		}
+	}
+
+	return size, found
 }
 
 // ParseConfig parses the configuration and returns a Config object
@@ -78,49 +654,1117 @@ func ParseConfig(cfg map[string]interface{}) (*Config, error) {
 		return nil, fmt.Errorf("%w: %s is missing", ErrInvalidConfig, AuthzServiceKey)
 	}
 
-	// Verify authorization service endpoint
-	authzURL, authzURLVerifyErr := stringRequired(authzSvc, AuthnServiceEndpointKey)
-	if authzURLVerifyErr != nil {
-		return nil, authzURLVerifyErr
+	// Verify authorization service type
+	authzType, authzTypeVerifyErr := getOrDefault(authzSvc, AuthzServiceTypeKey, AuthzServiceTypePermissionsAPI)
+	if authzTypeVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s.%s should be a string", ErrInvalidConfig, AuthzServiceKey, AuthzServiceTypeKey)
 	}
 
-	parsedURL, err := url.Parse(authzURL)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s is not a valid URL", ErrInvalidConfig, AuthzServiceKey)
+	var (
+		parsedURL       *url.URL
+		policyFile      string
+		policyReload    int
+		subjectClaim    string
+		allowedHeaders  []string
+		upstreamHeaders []string
+		err             error
+	)
+
+	switch authzType {
+	case AuthzServiceTypePermissionsAPI, AuthzServiceTypeHTTP, AuthzServiceTypeExtAuthz:
+		// Verify authorization service endpoint
+		authzURL, authzURLVerifyErr := stringRequired(authzSvc, AuthnServiceEndpointKey)
+		if authzURLVerifyErr != nil {
+			return nil, authzURLVerifyErr
+		}
+
+		parsedURL, err = url.Parse(authzURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a valid URL", ErrInvalidConfig, AuthzServiceKey)
+		}
+
+		if authzType == AuthzServiceTypeExtAuthz {
+			// Verify forwarded headers
+			if allowedHeaders, err = headerNamesOrDefault(authzSvc, AuthzServiceAllowedHeadersKey); err != nil {
+				return nil, err
+			}
+
+			if upstreamHeaders, err = headerNamesOrDefault(authzSvc, AuthzServiceAllowedUpstreamHeadersKey); err != nil {
+				return nil, err
+			}
+		}
+	case AuthzServiceTypeStatic:
+		// Verify policy file
+		policyFile, err = stringRequired(authzSvc, AuthzServicePolicyFileKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s", err, AuthzServiceKey, AuthzServicePolicyFileKey)
+		}
+
+		policyReload, err = intOrDefault(authzSvc, AuthzServicePolicyReloadIntervalKey, defaultPolicyReload)
+		if err != nil || policyReload < 0 {
+			return nil, fmt.Errorf("%w: %s.%s should be a positive number or 0", ErrInvalidConfig, AuthzServiceKey, AuthzServicePolicyReloadIntervalKey)
+		}
+
+		subjectClaim, err = getOrDefault(authzSvc, AuthzServiceSubjectClaimKey, DefaultSubjectClaim)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s should be a string", ErrInvalidConfig, AuthzServiceKey, AuthzServiceSubjectClaimKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s.%s %q is not supported", ErrInvalidConfig, AuthzServiceKey, AuthzServiceTypeKey, authzType)
 	}
 
 	// Get and verify timeout
-	tmout, tmoutErr := getOrDefault(authzSvc, AuthnServiceTimeoutKey, 1000)
-	if tmoutErr != nil {
+	tmout, tmoutErr := intOrDefault(authzSvc, AuthnServiceTimeoutKey, defaultAuthzTimeout)
+	if tmoutErr != nil || tmout < 0 {
 		return nil, fmt.Errorf("%w: %s is not a valid timeout", ErrInvalidConfig, AuthnServiceTimeoutKey)
 	}
 
-	// Verify action
-	action, actionVerifyErr := stringRequired(pconf, ActionKey)
-	if actionVerifyErr != nil {
-		return nil, actionVerifyErr
+	if tmout == 0 {
+		tmout = defaultAuthzTimeout
 	}
 
-	// Verify resource type
-	resourceType, resourceTypeVerifyErr := stringRequired(pconf, ResourceTypeKey)
-	if resourceTypeVerifyErr != nil {
-		return nil, resourceTypeVerifyErr
+	// Get and verify transport settings
+	transport := map[string]int{
+		AuthzServiceMaxIdleConnsKey:        defaultMaxIdleConns,
+		AuthzServiceMaxIdleConnsPerHostKey: defaultMaxIdleConns,
+		AuthzServiceIdleConnTimeoutKey:     defaultIdleTimeout,
+		AuthzServiceDialTimeoutKey:         defaultDialTimeout,
+		AuthzServiceTLSHandshakeTimeoutKey: defaultTLSTimeout,
+	}
+
+	for key, def := range transport {
+		val, err := intOrDefault(authzSvc, key, def)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, AuthzServiceKey, key)
+		}
+
+		transport[key] = val
+	}
+
+	// Verify circuit breaker
+	circuitBreaker, circuitBreakerVerifyErr := parseCircuitBreakerConfig(authzSvc)
+	if circuitBreakerVerifyErr != nil {
+		return nil, circuitBreakerVerifyErr
+	}
+
+	if circuitBreaker != nil && authzType == AuthzServiceTypeStatic {
+		return nil, fmt.Errorf("%w: %s.%s is not supported by the %s type", ErrInvalidConfig, AuthzServiceKey, AuthzServiceCircuitBreakerKey, authzType)
+	}
+
+	// Verify URN namespaces
+	urnNamespace, urnNamespaceVerifyErr := urnNamespaceOrDefault(pconf, URNNamespaceKey, DefaultURNNamespace)
+	if urnNamespaceVerifyErr != nil {
+		return nil, urnNamespaceVerifyErr
+	}
+
+	urnCompatNamespace, urnCompatNamespaceVerifyErr := urnNamespaceOrDefault(pconf, URNCompatNamespaceKey, "")
+	if urnCompatNamespaceVerifyErr != nil {
+		return nil, urnCompatNamespaceVerifyErr
+	}
+
+	if strings.EqualFold(urnNamespace, urnCompatNamespace) {
+		urnCompatNamespace = ""
+	}
+
+	// Verify checks, either a list of checks or a single check at the top level
+	checks, checksVerifyErr := parseChecks(pconf, urnNamespace)
+	if checksVerifyErr != nil {
+		return nil, checksVerifyErr
+	}
+
+	// The permissions-api only accepts URNs of UUIDs, which prefixed IDs cannot be converted to
+	if authzType == AuthzServiceTypePermissionsAPI {
+		for _, check := range checks {
+			if contains(check.ResourceIDFormats, ResourceIDFormatPrefixed) {
+				return nil, fmt.Errorf("%w: the %s format is not supported by the %s type", ErrInvalidConfig, ResourceIDFormatPrefixed, authzType)
+			}
+		}
+	}
+
+	checksMode, checksModeVerifyErr := getOrDefault(pconf, ChecksModeKey, ChecksModeAll)
+	if checksModeVerifyErr != nil || (checksMode != ChecksModeAll && checksMode != ChecksModeAny) {
+		return nil, fmt.Errorf("%w: %s should be either %s or %s", ErrInvalidConfig, ChecksModeKey, ChecksModeAll, ChecksModeAny)
+	}
+
+	// Verify decision cache
+	cache, cacheErr := parseCacheConfig(pconf)
+	if cacheErr != nil {
+		return nil, cacheErr
+	}
+
+	// ext_authz decisions depend on the whole request, not only on the token, action and resource
+	if cache != nil && authzType == AuthzServiceTypeExtAuthz {
+		return nil, fmt.Errorf("%w: %s is not supported by the %s type", ErrInvalidConfig, CacheKey, authzType)
+	}
+
+	// Verify authorization error policy
+	onAuthzError, onAuthzErrorVerifyErr := getOrDefault(pconf, OnAuthzErrorKey, OnAuthzErrorDeny)
+	if onAuthzErrorVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, OnAuthzErrorKey)
+	}
+
+	switch onAuthzError {
+	case OnAuthzErrorDeny, OnAuthzErrorAllow:
+	case OnAuthzErrorAllowStale:
+		if cache == nil {
+			return nil, fmt.Errorf("%w: %s %s requires %s to be configured", ErrInvalidConfig, OnAuthzErrorKey, OnAuthzErrorAllowStale, CacheKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s %q is not supported", ErrInvalidConfig, OnAuthzErrorKey, onAuthzError)
+	}
+
+	// Verify invalid resource status code
+	invalidResourceStatus, invalidResourceStatusVerifyErr := intOrDefault(pconf, InvalidResourceStatusKey, http.StatusBadRequest)
+	if invalidResourceStatusVerifyErr != nil ||
+		(invalidResourceStatus != http.StatusBadRequest && invalidResourceStatus != http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: %s should be either 400 or 404", ErrInvalidConfig, InvalidResourceStatusKey)
+	}
+
+	// Verify custom error messages
+	errorMessages, errorMessagesVerifyErr := parseErrorMessages(pconf)
+	if errorMessagesVerifyErr != nil {
+		return nil, errorMessagesVerifyErr
+	}
+
+	// Verify request ID header
+	requestIDHeader, requestIDHeaderVerifyErr := getOrDefault(pconf, RequestIDHeaderKey, DefaultRequestIDHeader)
+	if requestIDHeaderVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, RequestIDHeaderKey)
+	}
+
+	// Verify enforcement mode
+	mode, modeVerifyErr := parseMode(pconf)
+	if modeVerifyErr != nil {
+		return nil, modeVerifyErr
+	}
+
+	// Verify enforcement rollout
+	enforcePercent, enforcePercentVerifyErr := intOrDefault(pconf, EnforcePercentKey, 100)
+	if enforcePercentVerifyErr != nil || enforcePercent < 0 || enforcePercent > 100 {
+		return nil, fmt.Errorf("%w: %s should be a number between 0 and 100", ErrInvalidConfig, EnforcePercentKey)
+	}
+
+	enforcePercentBy, enforcePercentByVerifyErr := getOrDefault(pconf, EnforcePercentByKey, EnforcePercentBySubject)
+	if enforcePercentByVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, EnforcePercentByKey)
+	}
+
+	if enforcePercentBy != EnforcePercentBySubject && enforcePercentBy != EnforcePercentByRandom {
+		return nil, fmt.Errorf("%w: %s %q is not supported", ErrInvalidConfig, EnforcePercentByKey, enforcePercentBy)
+	}
+
+	// Verify audit log
+	audit, auditVerifyErr := parseAuditConfig(pconf)
+	if auditVerifyErr != nil {
+		return nil, auditVerifyErr
+	}
+
+	// Verify endpoint name
+	endpointName, endpointNameVerifyErr := getOrDefault(pconf, EndpointNameKey, "")
+	if endpointNameVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, EndpointNameKey)
+	}
+
+	// Verify metrics listener
+	metrics, metricsVerifyErr := parseMetricsConfig(pconf)
+	if metricsVerifyErr != nil {
+		return nil, metricsVerifyErr
+	}
+
+	// Verify logging
+	logLevel, logLevelVerifyErr := getOrDefault(pconf, LogLevelKey, "info")
+	if logLevelVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, LogLevelKey)
+	}
+
+	switch logLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("%w: %s %q is not supported", ErrInvalidConfig, LogLevelKey, logLevel)
+	}
+
+	logAllowSample, logAllowSampleVerifyErr := intOrDefault(pconf, LogAllowSampleKey, 1)
+	if logAllowSampleVerifyErr != nil || logAllowSample <= 0 {
+		return nil, fmt.Errorf("%w: %s should be a positive number", ErrInvalidConfig, LogAllowSampleKey)
+	}
+
+	// Verify JWT validation
+	jwt, jwtVerifyErr := parseJWTConfig(pconf)
+	if jwtVerifyErr != nil {
+		return nil, jwtVerifyErr
+	}
+
+	// Verify token introspection
+	introspection, introspectionVerifyErr := parseIntrospectionConfig(pconf)
+	if introspectionVerifyErr != nil {
+		return nil, introspectionVerifyErr
+	}
+
+	// Verify required scopes
+	requiredScopes, requiredScopesVerifyErr := stringSliceOrDefault(pconf, RequiredScopesKey, nil)
+	if requiredScopesVerifyErr != nil {
+		return nil, fmt.Errorf("%w: %s should be a list of scopes", ErrInvalidConfig, RequiredScopesKey)
+	}
+
+	for _, scope := range requiredScopes {
+		if !scopeRegex.MatchString(scope) {
+			return nil, fmt.Errorf("%w: %s contains an invalid scope %q", ErrInvalidConfig, RequiredScopesKey, scope)
+		}
 	}
 
-	// Verify resource path param
-	resourceParam, resourceParamVerifyErr := stringRequired(pconf, ResourceParamKey)
-	if resourceParamVerifyErr != nil {
-		return nil, resourceParamVerifyErr
+	// Verify injected headers
+	injectHeaders, injectHeadersVerifyErr := parseInjectHeadersConfig(pconf)
+	if injectHeadersVerifyErr != nil {
+		return nil, injectHeadersVerifyErr
 	}
 
 	return &Config{
 		AuthorizationService: &AuthzService{
-			Endpoint: parsedURL,
-			Timeout:  tmout,
+			Type:                   authzType,
+			Endpoint:               parsedURL,
+			PolicyFile:             policyFile,
+			PolicyReloadInterval:   policyReload,
+			AllowedHeaders:         allowedHeaders,
+			AllowedUpstreamHeaders: upstreamHeaders,
+			SubjectClaim:           subjectClaim,
+			Timeout:                tmout,
+			MaxIdleConns:           transport[AuthzServiceMaxIdleConnsKey],
+			MaxIdleConnsPerHost:    transport[AuthzServiceMaxIdleConnsPerHostKey],
+			IdleConnTimeout:        transport[AuthzServiceIdleConnTimeoutKey],
+			DialTimeout:            transport[AuthzServiceDialTimeoutKey],
+			TLSHandshakeTimeout:    transport[AuthzServiceTLSHandshakeTimeoutKey],
+			CircuitBreaker:         circuitBreaker,
 		},
-		Action:        action,
-		ResourceType:  resourceType,
-		ResourceParam: resourceParam,
+		Checks:                checks,
+		ChecksMode:            checksMode,
+		URNNamespace:          urnNamespace,
+		URNCompatNamespace:    urnCompatNamespace,
+		Cache:                 cache,
+		OnAuthzError:          onAuthzError,
+		InvalidResourceStatus: invalidResourceStatus,
+		ErrorMessages:         errorMessages,
+		RequestIDHeader:       requestIDHeader,
+		Mode:                  mode,
+		EnforcePercent:        enforcePercent,
+		EnforcePercentBy:      enforcePercentBy,
+		Audit:                 audit,
+		EndpointName:          endpointName,
+		Metrics:               metrics,
+		LogLevel:              logLevel,
+		LogAllowSample:        logAllowSample,
+		JWT:                   jwt,
+		Introspection:         introspection,
+		RequiredScopes:        requiredScopes,
+		InjectHeaders:         injectHeaders,
+	}, nil
+}
+
+// parseMode parses the enforcement mode. Endpoints which do not configure one use the
+// mode of the PORTON_MODE environment variable, or enforce if it is not set.
+func parseMode(pconf map[string]interface{}) (string, error) {
+	def := ModeEnforce
+	if env := os.Getenv(ModeEnvVar); env != "" {
+		def = env
+	}
+
+	mode, err := getOrDefault(pconf, ModeKey, def)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, ModeKey)
+	}
+
+	switch mode {
+	case ModeEnforce, ModeShadow:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: %s %q is not supported", ErrInvalidConfig, ModeKey, mode)
+	}
+}
+
+// checkKeys are the keys of the settings of a single check
+var checkKeys = []string{
+	ActionKey,
+	ActionsKey,
+	ResourceTypeKey,
+	ResourceIDFormatsKey,
+	ResourceIDPrefixesKey,
+	ResourceParamKey,
+	ResourceSourceKey,
+	CreateKey,
+}
+
+// parseChecks parses the list of checks. When no list is configured, the check
+// settings at the top level of the configuration make up a single check.
+func parseChecks(pconf map[string]interface{}, urnNamespace string) ([]*Check, error) {
+	if pconf[ChecksKey] == nil {
+		check, err := parseCheck(pconf, urnNamespace)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Check{check}, nil
+	}
+
+	for _, key := range checkKeys {
+		if pconf[key] != nil {
+			return nil, fmt.Errorf("%w: %s cannot be set along with %s", ErrInvalidConfig, key, ChecksKey)
+		}
+	}
+
+	checksConf, ok := pconf[ChecksKey].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a list", ErrInvalidConfig, ChecksKey)
+	}
+
+	if len(checksConf) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", ErrInvalidConfig, ChecksKey)
+	}
+
+	checks := make([]*Check, 0, len(checksConf))
+
+	for i, item := range checksConf {
+		checkConf, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s[%d] should be a map", ErrInvalidConfig, ChecksKey, i)
+		}
+
+		check, err := parseCheck(checkConf, urnNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", ChecksKey, i, err)
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// parseCheck parses the settings of a single check
+func parseCheck(conf map[string]interface{}, urnNamespace string) (*Check, error) {
+	// Verify resource type
+	resourceType, resourceTypeVerifyErr := stringRequired(conf, ResourceTypeKey)
+	if resourceTypeVerifyErr != nil {
+		return nil, resourceTypeVerifyErr
+	}
+
+	if _, err := urnx.Build(urnNamespace, resourceType, uuid.Nil); err != nil {
+		return nil, fmt.Errorf("%w: %s is not a valid URN resource type", ErrInvalidConfig, ResourceTypeKey)
+	}
+
+	// Verify create mode
+	create, createVerifyErr := parseCreateCheck(conf, resourceType, urnNamespace)
+	if createVerifyErr != nil {
+		return nil, createVerifyErr
+	}
+
+	// Verify action and actions. At least one of them must be set, unless in create mode.
+	var (
+		action  string
+		actions map[string]string
+	)
+
+	if create == nil {
+		var actionsVerifyErr error
+
+		actions, actionsVerifyErr = parseActions(conf)
+		if actionsVerifyErr != nil {
+			return nil, actionsVerifyErr
+		}
+
+		if conf[ActionKey] != nil || actions == nil {
+			var actionVerifyErr error
+
+			action, actionVerifyErr = stringRequired(conf, ActionKey)
+			if actionVerifyErr != nil {
+				return nil, actionVerifyErr
+			}
+		}
+	}
+
+	// Verify resource ID formats, which in create mode apply to the parent resource ID
+	idResourceType := resourceType
+	if create != nil {
+		idResourceType = create.ParentResourceType
+	}
+
+	resourceIDFormats, resourceIDPrefixes, resourceIDFormatsVerifyErr := parseResourceIDFormats(conf, idResourceType)
+	if resourceIDFormatsVerifyErr != nil {
+		return nil, resourceIDFormatsVerifyErr
+	}
+
+	// Verify resource path param or resource source
+	var resourceParam string
+
+	resourceSource, resourceSourceVerifyErr := parseResourceSource(conf)
+	if resourceSourceVerifyErr != nil {
+		return nil, resourceSourceVerifyErr
+	}
+
+	if resourceSource == nil {
+		var resourceParamVerifyErr error
+
+		resourceParam, resourceParamVerifyErr = stringRequired(conf, ResourceParamKey)
+		if resourceParamVerifyErr != nil {
+			return nil, resourceParamVerifyErr
+		}
+
+		resourceSource = &ResourceSource{
+			Type: ResourceSourceParam,
+			Name: resourceParam,
+		}
+	}
+
+	return &Check{
+		Action:             action,
+		Actions:            actions,
+		ResourceType:       resourceType,
+		ResourceIDFormats:  resourceIDFormats,
+		ResourceIDPrefixes: resourceIDPrefixes,
+		ResourceParam:      resourceParam,
+		ResourceSource:     resourceSource,
+		Create:             create,
+	}, nil
+}
+
+// parseCreateCheck parses the optional create mode settings of a check.
+// It returns nil if create mode is not configured.
+func parseCreateCheck(conf map[string]interface{}, resourceType, urnNamespace string) (*CreateCheck, error) {
+	if conf[CreateKey] == nil {
+		return nil, nil
+	}
+
+	for _, key := range []string{ActionKey, ActionsKey} {
+		if conf[key] != nil {
+			return nil, fmt.Errorf("%w: %s cannot be set along with %s, use %s.%s instead", ErrInvalidConfig, key, CreateKey, CreateKey, ActionKey)
+		}
+	}
+
+	createConf, ok := conf[CreateKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, CreateKey)
+	}
+
+	parentType, err := stringRequired(createConf, CreateParentResourceTypeKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, CreateKey, CreateParentResourceTypeKey)
+	}
+
+	if _, err := urnx.Build(urnNamespace, parentType, uuid.Nil); err != nil {
+		return nil, fmt.Errorf("%w: %s.%s is not a valid URN resource type", ErrInvalidConfig, CreateKey, CreateParentResourceTypeKey)
+	}
+
+	action, err := getOrDefault(createConf, ActionKey, strings.ToLower(resourceType)+"_create")
+	if err != nil || action == "" {
+		return nil, fmt.Errorf("%w: %s.%s should be a non-empty string", ErrInvalidConfig, CreateKey, ActionKey)
+	}
+
+	return &CreateCheck{
+		ParentResourceType: parentType,
+		Action:             action,
+	}, nil
+}
+
+// validMethods are the HTTP methods accepted as keys of the actions map
+var validMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// parseActions parses the optional HTTP method to action mapping.
+// Methods are normalized to upper case. It returns nil if the mapping is not configured.
+func parseActions(pconf map[string]interface{}) (map[string]string, error) {
+	if pconf[ActionsKey] == nil {
+		return nil, nil
+	}
+
+	actionsConf, ok := pconf[ActionsKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, ActionsKey)
+	}
+
+	if len(actionsConf) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", ErrInvalidConfig, ActionsKey)
+	}
+
+	actions := make(map[string]string, len(actionsConf))
+
+	for method := range actionsConf {
+		normalized := strings.ToUpper(method)
+		if !validMethods[normalized] {
+			return nil, fmt.Errorf("%w: %s contains unknown HTTP method %q", ErrInvalidConfig, ActionsKey, method)
+		}
+
+		if _, ok := actions[normalized]; ok {
+			return nil, fmt.Errorf("%w: %s contains duplicate HTTP method %q", ErrInvalidConfig, ActionsKey, method)
+		}
+
+		action, err := stringRequired(actionsConf, method)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s", err, ActionsKey, method)
+		}
+
+		actions[normalized] = action
+	}
+
+	return actions, nil
+}
+
+// urnNamespaceOrDefault returns the URN namespace of the given key, or the default if it's not set.
+func urnNamespaceOrDefault(conf map[string]interface{}, key string, def string) (string, error) {
+	ns, err := getOrDefault(conf, key, def)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s should be a string", ErrInvalidConfig, key)
+	}
+
+	if ns == "" {
+		return "", nil
+	}
+
+	if _, err := urnx.Build(ns, "resource", uuid.Nil); err != nil {
+		return "", fmt.Errorf("%w: %s is not a valid URN namespace", ErrInvalidConfig, key)
+	}
+
+	return ns, nil
+}
+
+// prefixRegex matches valid prefixes of prefixed IDs
+var prefixRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// parseResourceIDFormats parses the accepted resource ID formats and, when the prefixed
+// format is accepted, the mapping of prefixes to resource types.
+func parseResourceIDFormats(pconf map[string]interface{}, resourceType string) ([]string, map[string]string, error) {
+	formats, err := stringSliceOrDefault(pconf, ResourceIDFormatsKey, []string{ResourceIDFormatUUID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(formats) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s is empty", ErrInvalidConfig, ResourceIDFormatsKey)
+	}
+
+	seen := map[string]bool{}
+
+	for _, format := range formats {
+		if _, ok := resourceIDParsers[format]; !ok {
+			return nil, nil, fmt.Errorf("%w: %s contains unknown format %q", ErrInvalidConfig, ResourceIDFormatsKey, format)
+		}
+
+		if seen[format] {
+			return nil, nil, fmt.Errorf("%w: %s contains duplicate format %q", ErrInvalidConfig, ResourceIDFormatsKey, format)
+		}
+
+		seen[format] = true
+	}
+
+	if !seen[ResourceIDFormatPrefixed] {
+		if pconf[ResourceIDPrefixesKey] != nil {
+			return nil, nil, fmt.Errorf("%w: %s requires the %s format", ErrInvalidConfig, ResourceIDPrefixesKey, ResourceIDFormatPrefixed)
+		}
+
+		return formats, nil, nil
+	}
+
+	prefixesConf, ok := pconf[ResourceIDPrefixesKey].(map[string]interface{})
+	if !ok || len(prefixesConf) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s is required by the %s format", ErrInvalidConfig, ResourceIDPrefixesKey, ResourceIDFormatPrefixed)
+	}
+
+	prefixes := make(map[string]string, len(prefixesConf))
+	matchesResourceType := false
+
+	for prefix := range prefixesConf {
+		if !prefixRegex.MatchString(prefix) {
+			return nil, nil, fmt.Errorf("%w: %s contains invalid prefix %q", ErrInvalidConfig, ResourceIDPrefixesKey, prefix)
+		}
+
+		prefixType, err := stringRequired(prefixesConf, prefix)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s.%s", err, ResourceIDPrefixesKey, prefix)
+		}
+
+		if _, err := urnx.Build(DefaultURNNamespace, prefixType, uuid.Nil); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s.%s is not a valid URN resource type", ErrInvalidConfig, ResourceIDPrefixesKey, prefix)
+		}
+
+		matchesResourceType = matchesResourceType || strings.EqualFold(prefixType, resourceType)
+		prefixes[prefix] = prefixType
+	}
+
+	if !matchesResourceType {
+		return nil, nil, fmt.Errorf("%w: %s has no prefix for resource type %q", ErrInvalidConfig, ResourceIDPrefixesKey, resourceType)
+	}
+
+	return formats, prefixes, nil
+}
+
+// parseResourceSource parses the optional resource source configuration.
+// It returns nil if the resource source is not configured.
+func parseResourceSource(pconf map[string]interface{}) (*ResourceSource, error) {
+	if pconf[ResourceSourceKey] == nil {
+		return nil, nil
+	}
+
+	if pconf[ResourceParamKey] != nil {
+		return nil, fmt.Errorf("%w: only one of %s and %s can be set", ErrInvalidConfig, ResourceParamKey, ResourceSourceKey)
+	}
+
+	srcConf, ok := pconf[ResourceSourceKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, ResourceSourceKey)
+	}
+
+	srcType, err := getOrDefault(srcConf, ResourceSourceTypeKey, ResourceSourceParam)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s should be a string", ErrInvalidConfig, ResourceSourceKey, ResourceSourceTypeKey)
+	}
+
+	name, err := stringRequired(srcConf, ResourceSourceNameKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, ResourceSourceKey, ResourceSourceNameKey)
+	}
+
+	src := &ResourceSource{
+		Type: srcType,
+		Name: name,
+	}
+
+	switch srcType {
+	case ResourceSourceParam, ResourceSourceQuery, ResourceSourceHeader:
+	case ResourceSourceBody:
+		if !isValidJSONPointer(name) {
+			return nil, fmt.Errorf("%w: %s.%s should be a JSON pointer", ErrInvalidConfig, ResourceSourceKey, ResourceSourceNameKey)
+		}
+
+		src.MaxBodySize, err = intOrDefault(srcConf, ResourceSourceMaxBodySizeKey, defaultMaxBodySize)
+		if err != nil || src.MaxBodySize <= 0 {
+			return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, ResourceSourceKey, ResourceSourceMaxBodySizeKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s.%s %q is not supported", ErrInvalidConfig, ResourceSourceKey, ResourceSourceTypeKey, srcType)
+	}
+
+	return src, nil
+}
+
+// parseCircuitBreakerConfig parses the optional circuit breaker configuration of the
+// authorization service. It returns nil if the circuit breaker is not configured.
+func parseCircuitBreakerConfig(authzSvc map[string]interface{}) (*CircuitBreakerConfig, error) {
+	if authzSvc[AuthzServiceCircuitBreakerKey] == nil {
+		return nil, nil
+	}
+
+	cbConf, ok := authzSvc[AuthzServiceCircuitBreakerKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s.%s should be a map", ErrInvalidConfig, AuthzServiceKey, AuthzServiceCircuitBreakerKey)
+	}
+
+	threshold, err := intOrDefault(cbConf, CircuitBreakerFailureThresholdKey, defaultCBThreshold)
+	if err != nil || threshold <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s.%s should be a positive number", ErrInvalidConfig,
+			AuthzServiceKey, AuthzServiceCircuitBreakerKey, CircuitBreakerFailureThresholdKey)
+	}
+
+	openTimeout, err := intOrDefault(cbConf, CircuitBreakerOpenTimeoutKey, defaultCBOpenTimeout)
+	if err != nil || openTimeout <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s.%s should be a positive number", ErrInvalidConfig,
+			AuthzServiceKey, AuthzServiceCircuitBreakerKey, CircuitBreakerOpenTimeoutKey)
+	}
+
+	return &CircuitBreakerConfig{
+		FailureThreshold: threshold,
+		OpenTimeout:      openTimeout,
+	}, nil
+}
+
+// parseErrorMessages parses the optional custom error messages, keyed by error code.
+// It returns nil if no custom messages are configured.
+func parseErrorMessages(pconf map[string]interface{}) (map[string]string, error) {
+	if pconf[ErrorMessagesKey] == nil {
+		return nil, nil
+	}
+
+	msgsConf, ok := pconf[ErrorMessagesKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, ErrorMessagesKey)
+	}
+
+	msgs := make(map[string]string, len(msgsConf))
+
+	for code := range msgsConf {
+		if !errorCodes[code] {
+			return nil, fmt.Errorf("%w: %s contains unknown error code %q", ErrInvalidConfig, ErrorMessagesKey, code)
+		}
+
+		msg, err := stringRequired(msgsConf, code)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s", err, ErrorMessagesKey, code)
+		}
+
+		msgs[code] = msg
+	}
+
+	return msgs, nil
+}
+
+// parseJWTConfig parses the optional JWT verification configuration.
+// It returns nil if JWT verification is not configured.
+func parseJWTConfig(pconf map[string]interface{}) (*JWTConfig, error) {
+	if pconf[JWTKey] == nil {
+		return nil, nil
+	}
+
+	jwtConf, ok := pconf[JWTKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, JWTKey)
+	}
+
+	rawURL, err := stringRequired(jwtConf, JWTJWKSURLKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, JWTKey, JWTJWKSURLKey)
+	}
+
+	jwksURL, err := url.Parse(rawURL)
+	if err != nil || (jwksURL.Scheme != "http" && jwksURL.Scheme != "https") || jwksURL.Host == "" {
+		return nil, fmt.Errorf("%w: %s.%s should be an http or https URL", ErrInvalidConfig, JWTKey, JWTJWKSURLKey)
+	}
+
+	issuer, err := stringRequired(jwtConf, JWTIssuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, JWTKey, JWTIssuerKey)
+	}
+
+	audiences, err := stringSliceOrDefault(jwtConf, JWTAudiencesKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, JWTKey, JWTAudiencesKey)
+	}
+
+	algorithms, err := stringSliceOrDefault(jwtConf, JWTAlgorithmsKey, []string{"RS256"})
+	if err != nil || len(algorithms) == 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a non-empty list", ErrInvalidConfig, JWTKey, JWTAlgorithmsKey)
+	}
+
+	for _, alg := range algorithms {
+		if !jwtAlgorithms[alg] {
+			return nil, fmt.Errorf("%w: %s.%s contains unsupported algorithm %q", ErrInvalidConfig, JWTKey, JWTAlgorithmsKey, alg)
+		}
+	}
+
+	cfg := &JWTConfig{
+		JWKSURL:    jwksURL,
+		Issuer:     issuer,
+		Audiences:  audiences,
+		Algorithms: algorithms,
+	}
+
+	cfg.ClockSkew, err = intOrDefault(jwtConf, JWTClockSkewKey, defaultJWTClockSkew)
+	if err != nil || cfg.ClockSkew < 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number or 0", ErrInvalidConfig, JWTKey, JWTClockSkewKey)
+	}
+
+	cfg.JWKSCacheTTL, err = intOrDefault(jwtConf, JWTJWKSCacheTTLKey, defaultJWKSCacheTTL)
+	if err != nil || cfg.JWKSCacheTTL <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, JWTKey, JWTJWKSCacheTTLKey)
+	}
+
+	cfg.JWKSTimeout, err = intOrDefault(jwtConf, JWTJWKSTimeoutKey, defaultJWKSTimeout)
+	if err != nil || cfg.JWKSTimeout <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, JWTKey, JWTJWKSTimeoutKey)
+	}
+
+	return cfg, nil
+}
+
+// parseIntrospectionConfig parses the optional token introspection configuration.
+// It returns nil if token introspection is not configured.
+func parseIntrospectionConfig(pconf map[string]interface{}) (*IntrospectionConfig, error) {
+	if pconf[IntrospectionKey] == nil {
+		return nil, nil
+	}
+
+	introConf, ok := pconf[IntrospectionKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, IntrospectionKey)
+	}
+
+	rawURL, err := stringRequired(introConf, IntrospectionEndpointKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, IntrospectionKey, IntrospectionEndpointKey)
+	}
+
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: %s.%s should be an http or https URL", ErrInvalidConfig, IntrospectionKey, IntrospectionEndpointKey)
+	}
+
+	clientID, err := stringRequired(introConf, IntrospectionClientIDKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, IntrospectionKey, IntrospectionClientIDKey)
+	}
+
+	clientSecret, err := stringRequired(introConf, IntrospectionClientSecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, IntrospectionKey, IntrospectionClientSecretKey)
+	}
+
+	cfg := &IntrospectionConfig{
+		Endpoint:     endpoint,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+
+	cfg.Timeout, err = intOrDefault(introConf, IntrospectionTimeoutKey, defaultIntroTimeout)
+	if err != nil || cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, IntrospectionKey, IntrospectionTimeoutKey)
+	}
+
+	cfg.CacheTTL, err = intOrDefault(introConf, IntrospectionCacheTTLKey, defaultIntroCacheTTL)
+	if err != nil || cfg.CacheTTL < 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number or 0", ErrInvalidConfig, IntrospectionKey, IntrospectionCacheTTLKey)
+	}
+
+	cfg.CacheSize, err = intOrDefault(introConf, IntrospectionCacheSizeKey, defaultIntroCache)
+	if err != nil || cfg.CacheSize <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, IntrospectionKey, IntrospectionCacheSizeKey)
+	}
+
+	return cfg, nil
+}
+
+// scopeRegex matches valid OAuth2 scopes (RFC 6749)
+var scopeRegex = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+
+// headerNameRegex matches valid HTTP header names, made of token characters (RFC 7230)
+var headerNameRegex = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// headerNamesOrDefault returns the canonical header names of the given key of the authorization
+// service configuration, or nil if it's not set.
+func headerNamesOrDefault(authzSvc map[string]interface{}, key string) ([]string, error) {
+	names, err := stringSliceOrDefault(authzSvc, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s should be a list of header names", ErrInvalidConfig, AuthzServiceKey, key)
+	}
+
+	if names == nil {
+		return nil, nil
+	}
+
+	// the names are canonicalized in a copy, the configuration must not be modified
+	canonical := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if !headerNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("%w: %s.%s contains an invalid header name %q", ErrInvalidConfig, AuthzServiceKey, key, name)
+		}
+
+		canonical = append(canonical, http.CanonicalHeaderKey(name))
+	}
+
+	return canonical, nil
+}
+
+// parseInjectHeadersConfig parses the optional injected headers configuration.
+// It returns nil if no header is injected.
+func parseInjectHeadersConfig(pconf map[string]interface{}) (*InjectHeadersConfig, error) {
+	if pconf[InjectHeadersKey] == nil {
+		return nil, nil
+	}
+
+	headersConf, ok := pconf[InjectHeadersKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, InjectHeadersKey)
+	}
+
+	cfg := &InjectHeadersConfig{}
+
+	headers := []struct {
+		key  string
+		def  string
+		dest *string
+	}{
+		{InjectHeadersSubjectKey, DefaultSubjectHeader, &cfg.Subject},
+		{InjectHeadersResourceURNKey, DefaultResourceURNHeader, &cfg.ResourceURN},
+		{InjectHeadersActionKey, DefaultActionHeader, &cfg.Action},
+	}
+
+	for _, h := range headers {
+		name, err := getOrDefault(headersConf, h.key, h.def)
+		if err != nil || !headerNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("%w: %s.%s should be a valid header name", ErrInvalidConfig, InjectHeadersKey, h.key)
+		}
+
+		*h.dest = http.CanonicalHeaderKey(name)
+	}
+
+	return cfg, nil
+}
+
+// parseMetricsConfig parses the optional metrics listener configuration.
+// It returns nil if the metrics listener is not configured.
+func parseMetricsConfig(pconf map[string]interface{}) (*MetricsConfig, error) {
+	if pconf[MetricsKey] == nil {
+		return nil, nil
+	}
+
+	metricsConf, ok := pconf[MetricsKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, MetricsKey)
+	}
+
+	addr, err := stringRequired(metricsConf, MetricsListenAddressKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s.%s", err, MetricsKey, MetricsListenAddressKey)
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("%w: %s.%s should be a host:port address", ErrInvalidConfig, MetricsKey, MetricsListenAddressKey)
+	}
+
+	path, err := getOrDefault(metricsConf, MetricsPathKey, "/metrics")
+	if err != nil || !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("%w: %s.%s should be an absolute path", ErrInvalidConfig, MetricsKey, MetricsPathKey)
+	}
+
+	return &MetricsConfig{
+		ListenAddress: addr,
+		Path:          path,
+	}, nil
+}
+
+// parseAuditConfig parses the optional audit log configuration.
+// It returns nil if the audit log is not configured.
+func parseAuditConfig(pconf map[string]interface{}) (*AuditConfig, error) {
+	if pconf[AuditKey] == nil {
+		return nil, nil
+	}
+
+	auditConf, ok := pconf[AuditKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, AuditKey)
+	}
+
+	sinksConf, ok := auditConf[AuditSinksKey].([]interface{})
+	if !ok || len(sinksConf) == 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a non-empty list", ErrInvalidConfig, AuditKey, AuditSinksKey)
+	}
+
+	sinks := make([]*AuditSinkConfig, 0, len(sinksConf))
+
+	for i, item := range sinksConf {
+		sinkConf, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s.%s[%d] should be a map", ErrInvalidConfig, AuditKey, AuditSinksKey, i)
+		}
+
+		sink, err := parseAuditSinkConfig(sinkConf)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s[%d]: %w", AuditKey, AuditSinksKey, i, err)
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	return &AuditConfig{
+		Sinks: sinks,
+	}, nil
+}
+
+// parseAuditSinkConfig parses the settings of a single audit log sink
+func parseAuditSinkConfig(conf map[string]interface{}) (*AuditSinkConfig, error) {
+	sinkType, err := stringRequired(conf, AuditSinkTypeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &AuditSinkConfig{
+		Type: sinkType,
+	}
+
+	switch sinkType {
+	case AuditSinkLogger:
+	case AuditSinkFile:
+		if sink.Path, err = stringRequired(conf, AuditSinkPathKey); err != nil {
+			return nil, err
+		}
+
+		sink.MaxSize, err = intOrDefault(conf, AuditSinkMaxSizeKey, defaultAuditMaxSize)
+		if err != nil || sink.MaxSize <= 0 {
+			return nil, fmt.Errorf("%w: %s should be a positive number", ErrInvalidConfig, AuditSinkMaxSizeKey)
+		}
+
+		sink.MaxBackups, err = intOrDefault(conf, AuditSinkMaxBackupsKey, defaultAuditBackups)
+		if err != nil || sink.MaxBackups < 0 {
+			return nil, fmt.Errorf("%w: %s should be a positive number or 0", ErrInvalidConfig, AuditSinkMaxBackupsKey)
+		}
+	case AuditSinkWebhook:
+		rawURL, err := stringRequired(conf, AuditSinkURLKey)
+		if err != nil {
+			return nil, err
+		}
+
+		sink.URL, err = url.Parse(rawURL)
+		if err != nil || (sink.URL.Scheme != "http" && sink.URL.Scheme != "https") || sink.URL.Host == "" {
+			return nil, fmt.Errorf("%w: %s should be an http or https URL", ErrInvalidConfig, AuditSinkURLKey)
+		}
+
+		sink.Timeout, err = intOrDefault(conf, AuditSinkTimeoutKey, defaultAuditTimeout)
+		if err != nil || sink.Timeout <= 0 {
+			return nil, fmt.Errorf("%w: %s should be a positive number", ErrInvalidConfig, AuditSinkTimeoutKey)
+		}
+
+		sink.BufferSize, err = intOrDefault(conf, AuditSinkBufferSizeKey, defaultAuditBuffer)
+		if err != nil || sink.BufferSize <= 0 {
+			return nil, fmt.Errorf("%w: %s should be a positive number", ErrInvalidConfig, AuditSinkBufferSizeKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s %q is not supported", ErrInvalidConfig, AuditSinkTypeKey, sinkType)
+	}
+
+	return sink, nil
+}
+
+// parseCacheConfig parses the optional decision cache configuration.
+// It returns nil if the cache is not configured.
+func parseCacheConfig(pconf map[string]interface{}) (*CacheConfig, error) {
+	if pconf[CacheKey] == nil {
+		return nil, nil
+	}
+
+	cacheConf, ok := pconf[CacheKey].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s should be a map", ErrInvalidConfig, CacheKey)
+	}
+
+	size, err := intOrDefault(cacheConf, CacheSizeKey, defaultCacheSize)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("%w: %s.%s should be a positive number", ErrInvalidConfig, CacheKey, CacheSizeKey)
+	}
+
+	allowTTL, err := intOrDefault(cacheConf, CacheAllowTTLKey, defaultCacheAllowTTL)
+	if err != nil || allowTTL < 0 {
+		return nil, fmt.Errorf("%w: %s.%s is not a valid TTL", ErrInvalidConfig, CacheKey, CacheAllowTTLKey)
+	}
+
+	denyTTL, err := intOrDefault(cacheConf, CacheDenyTTLKey, defaultCacheDenyTTL)
+	if err != nil || denyTTL < 0 {
+		return nil, fmt.Errorf("%w: %s.%s is not a valid TTL", ErrInvalidConfig, CacheKey, CacheDenyTTLKey)
+	}
+
+	staleTTL, err := intOrDefault(cacheConf, CacheStaleTTLKey, defaultCacheStaleTTL)
+	if err != nil || staleTTL < 0 {
+		return nil, fmt.Errorf("%w: %s.%s is not a valid TTL", ErrInvalidConfig, CacheKey, CacheStaleTTLKey)
+	}
+
+	return &CacheConfig{
+		Size:     size,
+		AllowTTL: allowTTL,
+		DenyTTL:  denyTTL,
+		StaleTTL: staleTTL,
 	}, nil
 }
 
@@ -176,3 +1820,52 @@ func getOrDefault[T comparable](conf map[string]interface{}, key string, def T)
 
 	return conf[key].(T), nil
 }
+
+// intOrDefault returns the integer value of the given key, or the default if it's not set.
+// Numbers decoded from the krakend JSON configuration are float64, so those are accepted
+// as long as they hold a whole number.
+func intOrDefault(conf map[string]interface{}, key string, def int) (int, error) {
+	if conf == nil || conf[key] == nil {
+		return def, nil
+	}
+
+	switch v := conf[key].(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v != float64(int(v)) {
+			return def, fmt.Errorf("%w: %s is not a whole number", ErrInvalidConfig, key)
+		}
+
+		return int(v), nil
+	default:
+		return def, fmt.Errorf("%w: %s is of the wrong type", ErrInvalidConfig, key)
+	}
+}
+
+// stringSliceOrDefault returns the list of strings of the given key, or the default if it's not set.
+func stringSliceOrDefault(conf map[string]interface{}, key string, def []string) ([]string, error) {
+	if conf == nil || conf[key] == nil {
+		return def, nil
+	}
+
+	switch v := conf[key].(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		vals := make([]string, 0, len(v))
+
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok || str == "" {
+				return def, fmt.Errorf("%w: %s should only contain non-empty strings", ErrInvalidConfig, key)
+			}
+
+			vals = append(vals, str)
+		}
+
+		return vals, nil
+	default:
+		return def, fmt.Errorf("%w: %s should be a list", ErrInvalidConfig, key)
+	}
+}
@@ -41,17 +41,2072 @@ func TestParseConfig(t *testing.T) {
 			},
 			want: &Config{
 				AuthorizationService: &AuthzService{
-					Endpoint: mustParseURL(t, "http://authz"),
-					Timeout:  2000,
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             2000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
 				},
-				Action:        "read",
-				ResourceType:  "test",
-				ResourceParam: "test_id",
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with default timeout",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with float timeout",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+						"timeout":  float64(2000),
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             2000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with cache",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"cache": map[string]interface{}{
+						"size":      float64(100),
+						"allow_ttl": float64(60000),
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:   "all",
+				URNNamespace: "infratrographer",
+				Cache: &CacheConfig{
+					Size:     100,
+					AllowTTL: 60000,
+					DenyTTL:  5000,
+					StaleTTL: 300000,
+				},
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - invalid cache size",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"cache": map[string]interface{}{
+						"size": -1,
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid cache deny_ttl",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"cache": map[string]interface{}{
+						"deny_ttl": "5s",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with transport settings",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint":                "http://authz",
+						"max_idle_conns":          float64(10),
+						"max_idle_conns_per_host": float64(5),
+						"idle_conn_timeout":       float64(1000),
+						"dial_timeout":            float64(200),
+						"tls_handshake_timeout":   float64(300),
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        10,
+					MaxIdleConnsPerHost: 5,
+					IdleConnTimeout:     1000,
+					DialTimeout:         200,
+					TLSHandshakeTimeout: 300,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - invalid authz_service.dial_timeout",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint":     "http://authz",
+						"dial_timeout": "1s",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with actions",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"actions": map[string]interface{}{
+						"GET":    "loadbalancer_get",
+						"delete": "loadbalancer_delete",
+					},
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Actions: map[string]string{
+							"GET":    "loadbalancer_get",
+							"DELETE": "loadbalancer_delete",
+						},
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with actions and fallback action",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action": "loadbalancer_get",
+					"actions": map[string]interface{}{
+						"DELETE": "loadbalancer_delete",
+					},
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action: "loadbalancer_get",
+						Actions: map[string]string{
+							"DELETE": "loadbalancer_delete",
+						},
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - unknown method in actions",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"actions": map[string]interface{}{
+						"FETCH": "loadbalancer_get",
+					},
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - empty action in actions",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"actions": map[string]interface{}{
+						"GET": "",
+					},
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - empty actions",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"actions":        map[string]interface{}{},
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with body resource source",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":        "read",
+					"resource_type": "test",
+					"resource_source": map[string]interface{}{
+						"type": "body",
+						"name": "/parent/id",
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceSource: &ResourceSource{
+							Type:        "body",
+							Name:        "/parent/id",
+							MaxBodySize: 1048576,
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with query resource source",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":        "read",
+					"resource_type": "test",
+					"resource_source": map[string]interface{}{
+						"type": "query",
+						"name": "tenant_id",
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceSource: &ResourceSource{
+							Type: "query",
+							Name: "tenant_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - unknown resource source type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":        "read",
+					"resource_type": "test",
+					"resource_source": map[string]interface{}{
+						"type": "cookie",
+						"name": "tenant_id",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - body resource source without JSON pointer",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":        "read",
+					"resource_type": "test",
+					"resource_source": map[string]interface{}{
+						"type": "body",
+						"name": "parent.id",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - both resource_param and resource_source",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"resource_source": map[string]interface{}{
+						"type": "query",
+						"name": "test_id",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with urn namespaces",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":               "read",
+					"resource_type":        "test",
+					"resource_param":       "test_id",
+					"urn_namespace":        "staging",
+					"urn_compat_namespace": "infratrographer",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "staging",
+				URNCompatNamespace:    "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - invalid urn_namespace",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"urn_namespace":  "not a namespace!",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid urn_compat_namespace",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":               "read",
+					"resource_type":        "test",
+					"resource_param":       "test_id",
+					"urn_compat_namespace": 1234,
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid resource_type for urn",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "load balancer",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with resource id formats",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":     "http",
+						"endpoint": "http://authz",
+					},
+					"action":              "read",
+					"resource_type":       "loadbalancer",
+					"resource_param":      "test_id",
+					"resource_id_formats": []interface{}{"prefixed", "urn", "uuid"},
+					"resource_id_prefixes": map[string]interface{}{
+						"loadbal": "loadbalancer",
+						"tnntten": "tenant",
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "http",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "loadbalancer",
+						ResourceIDFormats: []string{"prefixed", "urn", "uuid"},
+						ResourceIDPrefixes: map[string]string{
+							"loadbal": "loadbalancer",
+							"tnntten": "tenant",
+						},
+						ResourceParam: "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - unknown resource id format",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":              "read",
+					"resource_type":       "test",
+					"resource_param":      "test_id",
+					"resource_id_formats": []interface{}{"uuid", "ulid"},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - prefixed format with the permissions-api",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":              "read",
+					"resource_type":       "loadbalancer",
+					"resource_param":      "test_id",
+					"resource_id_formats": []interface{}{"uuid", "prefixed"},
+					"resource_id_prefixes": map[string]interface{}{
+						"loadbal": "loadbalancer",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - prefixed format without prefixes",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":              "read",
+					"resource_type":       "test",
+					"resource_param":      "test_id",
+					"resource_id_formats": []interface{}{"prefixed"},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - no prefix for resource type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":              "read",
+					"resource_type":       "test",
+					"resource_param":      "test_id",
+					"resource_id_formats": []interface{}{"prefixed"},
+					"resource_id_prefixes": map[string]interface{}{
+						"tnntten": "tenant",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown on_authz_error policy",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"on_authz_error": "maybe",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - allow_stale without cache",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"on_authz_error": "allow_stale",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with circuit breaker",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+						"circuit_breaker": map[string]interface{}{
+							"failure_threshold": float64(3),
+						},
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+					CircuitBreaker: &CircuitBreakerConfig{
+						FailureThreshold: 3,
+						OpenTimeout:      10000,
+					},
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - invalid circuit breaker threshold",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+						"circuit_breaker": map[string]interface{}{
+							"failure_threshold": -1,
+						},
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid invalid_resource_status",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":                  "read",
+					"resource_type":           "test",
+					"resource_param":          "test_id",
+					"invalid_resource_status": float64(500),
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown error code in error_messages",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"error_messages": map[string]interface{}{
+						"teapot": "I'm a teapot",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with checks",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"checks": []interface{}{
+						map[string]interface{}{
+							"action":         "tenant_get",
+							"resource_type":  "tenant",
+							"resource_param": "tenant_id",
+						},
+						map[string]interface{}{
+							"actions": map[string]interface{}{
+								"GET":    "loadbalancer_get",
+								"DELETE": "loadbalancer_delete",
+							},
+							"resource_type":  "loadbalancer",
+							"resource_param": "lb_id",
+						},
+					},
+					"checks_mode": "any",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "tenant_get",
+						ResourceType:      "tenant",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "tenant_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "tenant_id",
+						},
+					},
+					{
+						Actions: map[string]string{
+							"GET":    "loadbalancer_get",
+							"DELETE": "loadbalancer_delete",
+						},
+						ResourceType:      "loadbalancer",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "lb_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "lb_id",
+						},
+					},
+				},
+				ChecksMode:            "any",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - checks along with top level check settings",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action": "read",
+					"checks": []interface{}{
+						map[string]interface{}{
+							"action":         "tenant_get",
+							"resource_type":  "tenant",
+							"resource_param": "tenant_id",
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid check",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"checks": []interface{}{
+						map[string]interface{}{
+							"action":        "tenant_get",
+							"resource_type": "tenant",
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown checks_mode",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"checks_mode":    "some",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with create mode",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"checks": []interface{}{
+						map[string]interface{}{
+							"resource_type":  "loadbalancer",
+							"resource_param": "tenant_id",
+							"create": map[string]interface{}{
+								"parent_resource_type": "tenant",
+							},
+						},
+						map[string]interface{}{
+							"resource_type": "loadbalancer",
+							"resource_source": map[string]interface{}{
+								"type": "body",
+								"name": "/project_id",
+							},
+							"create": map[string]interface{}{
+								"parent_resource_type": "project",
+								"action":               "project_loadbalancer_create",
+							},
+						},
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						ResourceType:      "loadbalancer",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "tenant_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "tenant_id",
+						},
+						Create: &CreateCheck{
+							ParentResourceType: "tenant",
+							Action:             "loadbalancer_create",
+						},
+					},
+					{
+						ResourceType:      "loadbalancer",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceSource: &ResourceSource{
+							Type:        "body",
+							Name:        "/project_id",
+							MaxBodySize: 1 << 20,
+						},
+						Create: &CreateCheck{
+							ParentResourceType: "project",
+							Action:             "project_loadbalancer_create",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - create mode along with action",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "loadbalancer_create",
+					"resource_type":  "loadbalancer",
+					"resource_param": "tenant_id",
+					"create": map[string]interface{}{
+						"parent_resource_type": "tenant",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - create mode without parent_resource_type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"resource_type":  "loadbalancer",
+					"resource_param": "tenant_id",
+					"create":         map[string]interface{}{},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - create mode prefixes without parent resource type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"resource_type":        "loadbalancer",
+					"resource_param":       "tenant_id",
+					"resource_id_formats":  []interface{}{"prefixed"},
+					"resource_id_prefixes": map[string]interface{}{"loadbal": "loadbalancer"},
+					"create": map[string]interface{}{
+						"parent_resource_type": "tenant",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown mode",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"mode":           "audit",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - enforce_percent out of range",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":          "read",
+					"resource_type":   "test",
+					"resource_param":  "test_id",
+					"enforce_percent": 101,
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown enforce_percent_by",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":             "read",
+					"resource_type":      "test",
+					"resource_param":     "test_id",
+					"enforce_percent":    50,
+					"enforce_percent_by": "tenant",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with audit sinks",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"audit": map[string]interface{}{
+						"sinks": []interface{}{
+							map[string]interface{}{
+								"type": "logger",
+							},
+							map[string]interface{}{
+								"type":        "file",
+								"path":        "/var/log/porton/audit.jsonl",
+								"max_backups": 2,
+							},
+							map[string]interface{}{
+								"type":    "webhook",
+								"url":     "https://audit.example.com/events",
+								"timeout": 2000,
+							},
+						},
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				Audit: &AuditConfig{
+					Sinks: []*AuditSinkConfig{
+						{
+							Type: "logger",
+						},
+						{
+							Type:       "file",
+							Path:       "/var/log/porton/audit.jsonl",
+							MaxSize:    100 << 20,
+							MaxBackups: 2,
+						},
+						{
+							Type:       "webhook",
+							URL:        mustParseURL(t, "https://audit.example.com/events"),
+							Timeout:    2000,
+							BufferSize: 1000,
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - unknown audit sink type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"audit": map[string]interface{}{
+						"sinks": []interface{}{
+							map[string]interface{}{
+								"type": "syslog",
+							},
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - audit webhook without url",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"audit": map[string]interface{}{
+						"sinks": []interface{}{
+							map[string]interface{}{
+								"type": "webhook",
+							},
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with metrics",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"endpoint_name":  "get-test",
+					"metrics": map[string]interface{}{
+						"listen_address": "127.0.0.1:9091",
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				EndpointName:          "get-test",
+				Metrics: &MetricsConfig{
+					ListenAddress: "127.0.0.1:9091",
+					Path:          "/metrics",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with jwt",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"jwks_url":   "https://issuer.example.com/.well-known/jwks.json",
+						"issuer":     "https://issuer.example.com",
+						"audiences":  []interface{}{"porton"},
+						"algorithms": []interface{}{"RS256", "ES256"},
+						"clock_skew": float64(0),
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				JWT: &JWTConfig{
+					JWKSURL:      mustParseURL(t, "https://issuer.example.com/.well-known/jwks.json"),
+					Issuer:       "https://issuer.example.com",
+					Audiences:    []string{"porton"},
+					Algorithms:   []string{"RS256", "ES256"},
+					ClockSkew:    0,
+					JWKSCacheTTL: 900000,
+					JWKSTimeout:  5000,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - jwt without jwks_url",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"issuer": "https://issuer.example.com",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - jwt without issuer",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"jwks_url": "https://issuer.example.com/.well-known/jwks.json",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - jwt with invalid jwks_url",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"jwks_url": "file:///etc/jwks.json",
+						"issuer":   "https://issuer.example.com",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - jwt with unsupported algorithm",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"jwks_url":   "https://issuer.example.com/.well-known/jwks.json",
+						"issuer":     "https://issuer.example.com",
+						"algorithms": []interface{}{"HS256"},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - jwt with negative clock_skew",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"jwt": map[string]interface{}{
+						"jwks_url":   "https://issuer.example.com/.well-known/jwks.json",
+						"issuer":     "https://issuer.example.com",
+						"clock_skew": -1,
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with inject_headers",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"inject_headers": map[string]interface{}{
+						"subject": "x-subject",
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				InjectHeaders: &InjectHeadersConfig{
+					Subject:     "X-Subject",
+					ResourceURN: "X-Infratographer-Resource-Urn",
+					Action:      "X-Infratographer-Action",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - invalid inject_headers name",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"inject_headers": map[string]interface{}{
+						"action": "X Action",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - inject_headers not a map",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"inject_headers": true,
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with static authz_service",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":        "static",
+						"policy_file": "/etc/porton/policy.json",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                 "static",
+					PolicyFile:           "/etc/porton/policy.json",
+					PolicyReloadInterval: 5000,
+					SubjectClaim:         "sub",
+					Timeout:              1000,
+					MaxIdleConns:         100,
+					MaxIdleConnsPerHost:  100,
+					IdleConnTimeout:      90000,
+					DialTimeout:          30000,
+					TLSHandshakeTimeout:  10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with http authz_service",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":     "http",
+						"endpoint": "http://authz/decide",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "http",
+					Endpoint:            mustParseURL(t, "http://authz/decide"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - unknown authz_service type",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":     "opa",
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - negative policy_reload_interval",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":                   "static",
+						"policy_file":            "/etc/porton/policy.json",
+						"policy_reload_interval": -1,
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - static authz_service without policy_file",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type": "static",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - static authz_service with circuit_breaker",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":            "static",
+						"policy_file":     "/etc/porton/policy.json",
+						"circuit_breaker": map[string]interface{}{},
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - http authz_service without endpoint",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type": "http",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with ext_authz authz_service",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":                     "ext_authz",
+						"endpoint":                 "http://authz/check",
+						"allowed_headers":          []interface{}{"x-tenant", "Cookie"},
+						"allowed_upstream_headers": []interface{}{"x-user-roles"},
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                   "ext_authz",
+					Endpoint:               mustParseURL(t, "http://authz/check"),
+					Timeout:                1000,
+					MaxIdleConns:           100,
+					MaxIdleConnsPerHost:    100,
+					IdleConnTimeout:        90000,
+					DialTimeout:            30000,
+					TLSHandshakeTimeout:    10000,
+					AllowedHeaders:         []string{"X-Tenant", "Cookie"},
+					AllowedUpstreamHeaders: []string{"X-User-Roles"},
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
 			},
 			wantErr: false,
 		},
 		{
-			name: "valid config with default timeout",
+			name: "invalid config - ext_authz authz_service with cache",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":     "ext_authz",
+						"endpoint": "http://authz/check",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"cache":          map[string]interface{}{},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - ext_authz authz_service with invalid allowed_headers",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"type":            "ext_authz",
+						"endpoint":        "http://authz/check",
+						"allowed_headers": []interface{}{"X Tenant"},
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with introspection",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"introspection": map[string]interface{}{
+						"endpoint":      "https://issuer.example.com/oauth2/introspect",
+						"client_id":     "porton",
+						"client_secret": "s3cr3t",
+						"cache_ttl":     float64(0),
+					},
+				},
+			},
+			want: &Config{
+				AuthorizationService: &AuthzService{
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
+				},
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				Introspection: &IntrospectionConfig{
+					Endpoint:     mustParseURL(t, "https://issuer.example.com/oauth2/introspect"),
+					ClientID:     "porton",
+					ClientSecret: "s3cr3t",
+					Timeout:      5000,
+					CacheTTL:     0,
+					CacheSize:    10000,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid config - introspection without client_secret",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"introspection": map[string]interface{}{
+						"endpoint":  "https://issuer.example.com/oauth2/introspect",
+						"client_id": "porton",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - introspection endpoint not an http URL",
 			cfg: map[string]interface{}{
 				PluginName: map[string]interface{}{
 					"authz_service": map[string]interface{}{
@@ -60,19 +2115,132 @@ func TestParseConfig(t *testing.T) {
 					"action":         "read",
 					"resource_type":  "test",
 					"resource_param": "test_id",
+					"introspection": map[string]interface{}{
+						"endpoint":      "issuer.example.com/oauth2/introspect",
+						"client_id":     "porton",
+						"client_secret": "s3cr3t",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "valid config with required_scopes",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":          "read",
+					"resource_type":   "test",
+					"resource_param":  "test_id",
+					"required_scopes": []interface{}{"loadbalancer:read", "https://api.example.com/lb"},
 				},
 			},
 			want: &Config{
 				AuthorizationService: &AuthzService{
-					Endpoint: mustParseURL(t, "http://authz"),
-					Timeout:  1000,
+					Type:                "permissions-api",
+					Endpoint:            mustParseURL(t, "http://authz"),
+					Timeout:             1000,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90000,
+					DialTimeout:         30000,
+					TLSHandshakeTimeout: 10000,
+				},
+				Checks: []*Check{
+					{
+						Action:            "read",
+						ResourceType:      "test",
+						ResourceIDFormats: []string{"uuid"},
+						ResourceParam:     "test_id",
+						ResourceSource: &ResourceSource{
+							Type: "param",
+							Name: "test_id",
+						},
+					},
 				},
-				Action:        "read",
-				ResourceType:  "test",
-				ResourceParam: "test_id",
+				ChecksMode:            "all",
+				URNNamespace:          "infratrographer",
+				OnAuthzError:          "deny",
+				InvalidResourceStatus: 400,
+				RequestIDHeader:       "X-Request-Id",
+				Mode:                  "enforce",
+				EnforcePercent:        100,
+				EnforcePercentBy:      "subject",
+				LogLevel:              "info",
+				LogAllowSample:        1,
+				RequiredScopes:        []string{"loadbalancer:read", "https://api.example.com/lb"},
 			},
 			wantErr: false,
 		},
+		{
+			name: "invalid config - required_scopes with an invalid scope",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":          "read",
+					"resource_type":   "test",
+					"resource_param":  "test_id",
+					"required_scopes": []interface{}{"loadbalancer:read loadbalancer:write"},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid metrics listen_address",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"metrics": map[string]interface{}{
+						"listen_address": "localhost",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - unknown log_level",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":         "read",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"log_level":      "trace",
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "invalid config - invalid log_allow_sample",
+			cfg: map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": "http://authz",
+					},
+					"action":           "read",
+					"resource_type":    "test",
+					"resource_param":   "test_id",
+					"log_allow_sample": 0,
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name: "invalid config - missing authz_service",
 			cfg: map[string]interface{}{
@@ -253,3 +2421,125 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseConfigMode(t *testing.T) {
+	newCfg := func(mode string) map[string]interface{} {
+		pconf := map[string]interface{}{
+			"authz_service": map[string]interface{}{
+				"endpoint": "http://authz",
+			},
+			"action":         "read",
+			"resource_type":  "test",
+			"resource_param": "test_id",
+		}
+
+		if mode != "" {
+			pconf["mode"] = mode
+		}
+
+		return map[string]interface{}{PluginName: pconf}
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: ModeEnforce,
+		},
+		{
+			name: "endpoint mode",
+			mode: ModeShadow,
+			want: ModeShadow,
+		},
+		{
+			name: "global mode",
+			env:  ModeShadow,
+			want: ModeShadow,
+		},
+		{
+			name: "endpoint mode overrides global mode",
+			env:  ModeShadow,
+			mode: ModeEnforce,
+			want: ModeEnforce,
+		},
+		{
+			name:    "invalid global mode",
+			env:     "audit",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ModeEnvVar, tt.env)
+
+			got, err := ParseConfig(newCfg(tt.mode))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidConfig)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Mode)
+		})
+	}
+}
+
+func TestParseConfigHeaderNamesNotModified(t *testing.T) {
+	t.Parallel()
+
+	allowed := []string{"x-tenant"}
+	upstream := []string{"x-user-roles"}
+
+	cfg, err := ParseConfig(map[string]interface{}{
+		PluginName: map[string]interface{}{
+			"authz_service": map[string]interface{}{
+				"type":                     "ext_authz",
+				"endpoint":                 "http://authz/check",
+				"allowed_headers":          allowed,
+				"allowed_upstream_headers": upstream,
+			},
+			"action":         "read",
+			"resource_type":  "test",
+			"resource_param": "test_id",
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"X-Tenant"}, cfg.AuthorizationService.AllowedHeaders)
+	assert.Equal(t, []string{"X-User-Roles"}, cfg.AuthorizationService.AllowedUpstreamHeaders)
+	assert.Equal(t, []string{"x-tenant"}, allowed, "expected the configuration not to be modified")
+	assert.Equal(t, []string{"x-user-roles"}, upstream, "expected the configuration not to be modified")
+}
+
+func TestCheckActionFor(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Check{
+		Action: "fallback",
+		Actions: map[string]string{
+			"GET":    "get",
+			"DELETE": "delete",
+		},
+	}
+
+	assert.Equal(t, "get", cfg.actionFor("GET"))
+	assert.Equal(t, "get", cfg.actionFor("get"))
+	assert.Equal(t, "delete", cfg.actionFor("DELETE"))
+	assert.Equal(t, "fallback", cfg.actionFor("PUT"))
+
+	noFallback := &Check{
+		Actions: map[string]string{
+			"GET": "get",
+		},
+	}
+
+	assert.Empty(t, noFallback.actionFor("PUT"), "unmapped methods without a fallback action should have no action")
+}
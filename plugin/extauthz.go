@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ExtAuthzActionHeader is the header carrying the action checked in ext_authz requests
+	ExtAuthzActionHeader = "X-Porton-Action"
+	// ExtAuthzResourceURNHeader is the header carrying the resource URN checked in ext_authz requests
+	ExtAuthzResourceURNHeader = "X-Porton-Resource-Urn"
+)
+
+// extAuthzAuthorizer takes decisions with an authorization service speaking the HTTP protocol
+// of Envoy's ext_authz filter. The request is replayed without its body against the endpoint,
+// with its path appended to the endpoint path, its allowed headers, the Authorization header
+// and the action and resource URN checked. A 2xx response allows the request, a 403 denies
+// it, a 401 rejects its token, and any other response is an error.
+type extAuthzAuthorizer struct {
+	endpoint *url.URL
+	client   *http.Client
+
+	// allowedHeaders are the request headers sent to the authorization service
+	allowedHeaders []string
+	// allowedUpstreamHeaders are the headers of allowing responses added to the request
+	// sent to the backend
+	allowedUpstreamHeaders []string
+}
+
+// newExtAuthzAuthorizer returns an ext_authz authorizer for the given authorization service
+func newExtAuthzAuthorizer(svc *AuthzService) *extAuthzAuthorizer {
+	return &extAuthzAuthorizer{
+		endpoint:               svc.Endpoint,
+		client:                 newAuthzHTTPClient(svc),
+		allowedHeaders:         svc.AllowedHeaders,
+		allowedUpstreamHeaders: svc.AllowedUpstreamHeaders,
+	}
+}
+
+// Authorize asks the authorization service whether the request is allowed
+func (a *extAuthzAuthorizer) Authorize(ctx context.Context, req *AuthzRequest) (Decision, error) {
+	u := *a.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + req.Request.Path()
+	u.RawPath = ""
+	u.RawQuery = req.Request.Query().Encode()
+
+	httpReq, err := http.NewRequestWithContext(contextWithToken(ctx, req.Token), req.Request.Method(), u.String(), nil)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	for name, values := range req.Request.Headers() {
+		if containsHeader(a.allowedHeaders, name) {
+			httpReq.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	httpReq.Header.Set(ExtAuthzActionHeader, req.Action)
+	httpReq.Header.Set(ExtAuthzResourceURNHeader, req.Resource)
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDecisionResponseSize))
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		d := Decision{Allowed: true}
+
+		for _, name := range a.allowedUpstreamHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				if d.Headers == nil {
+					d.Headers = map[string][]string{}
+				}
+
+				d.Headers[name] = values
+			}
+		}
+
+		return d, nil
+	case resp.StatusCode == http.StatusForbidden:
+		return Decision{}, nil
+	default:
+		return Decision{}, &authzStatusError{code: resp.StatusCode}
+	}
+}
@@ -0,0 +1,226 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeExtAuthz returns a fake ext_authz authorization service allowing GET requests to
+// /api/things?page=2 with the token "Bearer token" and the tenant header, failing for
+// /api/failing, rejecting the token "Bearer invalid" and denying other requests.
+func newFakeExtAuthz(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/authz/api/failing":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Header.Get(AuthorizationHeader) == "Bearer invalid":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodGet &&
+			r.URL.Path == "/authz/api/things" &&
+			r.URL.Query().Get("page") == "2" &&
+			r.Header.Get(AuthorizationHeader) == "Bearer token" &&
+			r.Header.Get("X-Tenant") == "tnntten-1" &&
+			r.Header.Get("X-Not-Allowed") == "" &&
+			r.Header.Get(ExtAuthzActionHeader) == "test_get" &&
+			r.Header.Get(ExtAuthzResourceURNHeader) != "":
+			w.Header().Set("X-User-Roles", "admin")
+			w.Header().Set("X-Internal", "secret")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestExtAuthzConfig(t *testing.T, endpoint string) *Config {
+	t.Helper()
+
+	cfg := newTestConfig(t, endpoint+"/authz")
+	cfg.AuthorizationService.Type = AuthzServiceTypeExtAuthz
+	cfg.AuthorizationService.AllowedHeaders = []string{"X-Tenant"}
+	cfg.AuthorizationService.AllowedUpstreamHeaders = []string{"X-User-Roles"}
+
+	return cfg
+}
+
+func TestExtAuthzAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeExtAuthz(t)
+
+	a, err := newAuthorizer(newTestExtAuthzConfig(t, server.URL).AuthorizationService)
+	require.NoError(t, err)
+
+	newReq := func(method, path, token string) *AuthzRequest {
+		return &AuthzRequest{
+			Token:    token,
+			Action:   "test_get",
+			Resource: "urn:infratographer:test:1",
+			Request: &testRequest{
+				method: method,
+				path:   path,
+				query:  url.Values{"page": {"2"}},
+				headers: map[string][]string{
+					AuthorizationHeader: {token},
+					"X-Tenant":          {"tnntten-1"},
+					"X-Not-Allowed":     {"value"},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		req         *AuthzRequest
+		want        bool
+		wantHeaders map[string][]string
+		wantErr     bool
+		wantErrIs   error
+	}{
+		{
+			name:        "allowed",
+			req:         newReq(http.MethodGet, "/api/things", "Bearer token"),
+			want:        true,
+			wantHeaders: map[string][]string{"X-User-Roles": {"admin"}},
+		},
+		{
+			name: "denied",
+			req:  newReq(http.MethodGet, "/api/things", "Bearer other"),
+			want: false,
+		},
+		{
+			name: "method forwarded",
+			req:  newReq(http.MethodDelete, "/api/things", "Bearer token"),
+			want: false,
+		},
+		{
+			name:    "server error",
+			req:     newReq(http.MethodGet, "/api/failing", "Bearer token"),
+			wantErr: true,
+		},
+		{
+			name:      "token rejected",
+			req:       newReq(http.MethodGet, "/api/things", "Bearer invalid"),
+			wantErr:   true,
+			wantErrIs: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := a.Authorize(context.Background(), tt.req)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				if tt.wantErrIs != nil {
+					assert.ErrorIs(t, err, tt.wantErrIs)
+				}
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, d.Allowed)
+			assert.Equal(t, tt.wantHeaders, d.Headers)
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestExtAuthzInvalidToken(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeExtAuthz(t)
+
+	cfg := newTestExtAuthzConfig(t, server.URL)
+	cfg.OnAuthzError = OnAuthzErrorAllow
+	cfg.AuthorizationService.CircuitBreaker = &CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      60000,
+	}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(token string) RequestWrapper {
+		return &testRequest{
+			method: http.MethodGet,
+			path:   "/api/things",
+			query:  url.Values{"page": {"2"}},
+			headers: map[string][]string{
+				AuthorizationHeader: {token},
+				"X-Tenant":          {"tnntten-1"},
+			},
+			params: map[string]string{"Test_id": uuid.NewString()},
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		allowed, err := authorize(h, newReq("Bearer invalid"))
+		assert.ErrorIs(t, err, ErrInvalidToken, "expected rejected tokens not to be let through")
+		assert.False(t, allowed)
+	}
+
+	allowed, err := authorize(h, newReq("Bearer token"))
+	require.NoError(t, err, "expected 401 answers not to open the circuit")
+	assert.True(t, allowed)
+}
+
+func TestRequestModPluginHandleExtAuthzHeaders(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeExtAuthz(t)
+
+	handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+		PluginName: map[string]interface{}{
+			"authz_service": map[string]interface{}{
+				"type":                     AuthzServiceTypeExtAuthz,
+				"endpoint":                 server.URL + "/authz",
+				"allowed_headers":          []interface{}{"x-tenant"},
+				"allowed_upstream_headers": []interface{}{"x-user-roles"},
+			},
+			"action":         "test_get",
+			"resource_type":  "test",
+			"resource_param": "test_id",
+		},
+	})
+
+	req := &testRequest{
+		method: http.MethodGet,
+		path:   "/api/things",
+		query:  url.Values{"page": {"2"}},
+		headers: map[string][]string{
+			AuthorizationHeader: {"Bearer token"},
+			"X-Tenant":          {"tnntten-1"},
+			"X-User-Roles":      {"spoofed"},
+		},
+		params: map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	got, err := handle(req)
+	require.NoError(t, err)
+
+	gotReq, ok := got.(RequestWrapper)
+	require.True(t, ok)
+
+	assert.Equal(t, map[string][]string{
+		AuthorizationHeader: {"Bearer token"},
+		"X-Tenant":          {"tnntten-1"},
+		"X-User-Roles":      {"admin"},
+	}, gotReq.Headers())
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeServer is a test server counting the requests it receives. It answers them with a
+// 500 while it's failing, and with its handler otherwise. The handler is called with mu
+// held, so that it can read the state of the fakes built on the server.
+type fakeServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   int
+	failing bool
+}
+
+func newFakeServer(t *testing.T, handler http.HandlerFunc) *fakeServer {
+	t.Helper()
+
+	s := &fakeServer{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.calls++
+
+		if s.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		handler(w, r)
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakeServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failing = failing
+}
+
+func (s *fakeServer) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
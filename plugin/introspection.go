@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrIntrospectionUnavailable is returned when the token introspection endpoint cannot be reached
+// or returns a response which cannot be interpreted
+var ErrIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// maxIntrospectionResponseSize is the maximum size of introspection responses in bytes
+const maxIntrospectionResponseSize = 1 << 20
+
+// introspectionResponse is the response of an introspection endpoint (RFC 7662)
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Subject   string   `json:"sub"`
+	Scope     string   `json:"scope"`
+	ClientID  string   `json:"client_id"`
+	ExpiresAt *float64 `json:"exp"`
+
+	// Claims are all the members of the response, such as the claims of the token
+	Claims map[string]interface{} `json:"-"`
+}
+
+// tokenInfo is what porton knows about an introspected token
+type tokenInfo struct {
+	// subject is the subject of the token
+	subject string
+	// clientID is the client the token was issued to
+	clientID string
+	// scopes are the scopes granted to the token
+	scopes []string
+	// claims are the members of the introspection response
+	claims map[string]interface{}
+}
+
+// introspector checks opaque tokens against an OAuth2 introspection endpoint. The results of
+// active tokens are cached until the cache TTL or the expiry of the token, whichever comes
+// first. Inactive tokens are not cached, so that tokens becoming valid are picked up.
+type introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	cache    *lruCache[*tokenInfo]
+	cacheTTL time.Duration
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// introspectors holds the introspectors shared by every endpoint using the same introspection
+// endpoint and client ID
+var introspectors = newRegistry[*introspector]("token introspection")
+
+// getIntrospector returns the introspector of the given configuration, creating it if needed.
+// It returns nil if token introspection is not configured. The settings of the first
+// configuration seen for an endpoint and client ID are used.
+func getIntrospector(cfg *IntrospectionConfig) *introspector {
+	if cfg == nil {
+		return nil
+	}
+
+	in, _ := introspectors.get(cfg.Endpoint.String()+" "+cfg.ClientID, *cfg, func() (*introspector, error) {
+		return &introspector{
+			endpoint:     cfg.Endpoint.String(),
+			clientID:     cfg.ClientID,
+			clientSecret: cfg.ClientSecret,
+			client: &http.Client{
+				Timeout: time.Duration(cfg.Timeout) * time.Millisecond,
+			},
+			cache:    newLRUCache[*tokenInfo](cfg.CacheSize),
+			cacheTTL: time.Duration(cfg.CacheTTL) * time.Millisecond,
+			now:      time.Now,
+		}, nil
+	})
+
+	return in
+}
+
+// introspect returns the information of the given token. It returns an error wrapping
+// ErrInvalidToken if the token is not active, or ErrIntrospectionUnavailable if the
+// introspection endpoint cannot be used.
+func (in *introspector) introspect(ctx context.Context, token string) (*tokenInfo, error) {
+	key := tokenCacheKey(token)
+	now := in.now()
+
+	if info, ok := in.cache.get(key, now); ok {
+		return info, nil
+	}
+
+	resp, err := in.call(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := now.Add(in.cacheTTL)
+
+	if resp.ExpiresAt != nil {
+		exp := numericDate(*resp.ExpiresAt)
+		if !now.Before(exp) {
+			return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+		}
+
+		if exp.Before(expires) {
+			expires = exp
+		}
+	}
+
+	info := &tokenInfo{
+		subject:  resp.Subject,
+		clientID: resp.ClientID,
+		scopes:   strings.Fields(resp.Scope),
+		claims:   resp.Claims,
+	}
+
+	if in.cacheTTL > 0 {
+		in.cache.set(key, info, expires)
+	}
+
+	return info, nil
+}
+
+// call posts the token to the introspection endpoint, authenticating with the client credentials
+func (in *introspector) call(ctx context.Context, token string) (*introspectionResponse, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIntrospectionUnavailable, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", HTTPJSONEncoding)
+	// the client credentials are form encoded before being used as basic auth (RFC 6749)
+	req.SetBasicAuth(url.QueryEscape(in.clientID), url.QueryEscape(in.clientSecret))
+
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIntrospectionUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrIntrospectionUnavailable, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response: %w", ErrIntrospectionUnavailable, err)
+	}
+
+	var ir introspectionResponse
+	if err := json.Unmarshal(body, &ir); err != nil {
+		return nil, fmt.Errorf("%w: error decoding response: %w", ErrIntrospectionUnavailable, err)
+	}
+
+	if err := json.Unmarshal(body, &ir.Claims); err != nil {
+		return nil, fmt.Errorf("%w: error decoding response: %w", ErrIntrospectionUnavailable, err)
+	}
+
+	if !ir.Active {
+		return nil, fmt.Errorf("%w: token is not active", ErrInvalidToken)
+	}
+
+	return &ir, nil
+}
+
+// isJWT reports whether the token looks like a JWT rather than an opaque token
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,287 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIntrospection is an introspection endpoint answering with the configured responses
+// of the tokens, and with an inactive token otherwise
+type testIntrospection struct {
+	*fakeServer
+
+	tokens map[string]map[string]interface{}
+}
+
+func newTestIntrospection(t *testing.T) *testIntrospection {
+	t.Helper()
+
+	s := &testIntrospection{
+		tokens: map[string]map[string]interface{}{},
+	}
+
+	s.fakeServer = newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "porton" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		resp, ok := s.tokens[r.PostFormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	return s
+}
+
+func (s *testIntrospection) setToken(token string, resp map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token] = resp
+}
+
+func newTestIntrospectionConfig(t *testing.T, endpoint string) *IntrospectionConfig {
+	t.Helper()
+
+	return &IntrospectionConfig{
+		Endpoint:     mustParseURL(t, endpoint),
+		ClientID:     "porton",
+		ClientSecret: "s3cr3t",
+		Timeout:      1000,
+		CacheTTL:     60000,
+		CacheSize:    10,
+	}
+}
+
+func TestIntrospectorIntrospect(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	server := newTestIntrospection(t)
+	server.setToken("active", map[string]interface{}{
+		"active":    true,
+		"sub":       "idntusr-1",
+		"client_id": "machine-1",
+		"scope":     "loadbalancer:read loadbalancer:write",
+		"exp":       exp,
+	})
+	server.setToken("expired", map[string]interface{}{
+		"active": true,
+		"sub":    "idntusr-1",
+		"exp":    time.Now().Add(-time.Hour).Unix(),
+	})
+
+	in := getIntrospector(newTestIntrospectionConfig(t, server.URL))
+
+	tests := []struct {
+		name      string
+		token     string
+		want      *tokenInfo
+		wantErrIs error
+	}{
+		{
+			name:  "active",
+			token: "active",
+			want: &tokenInfo{
+				subject:  "idntusr-1",
+				clientID: "machine-1",
+				scopes:   []string{"loadbalancer:read", "loadbalancer:write"},
+				claims: map[string]interface{}{
+					"active":    true,
+					"sub":       "idntusr-1",
+					"client_id": "machine-1",
+					"scope":     "loadbalancer:read loadbalancer:write",
+					"exp":       float64(exp),
+				},
+			},
+		},
+		{
+			name:      "inactive",
+			token:     "unknown",
+			wantErrIs: ErrInvalidToken,
+		},
+		{
+			name:      "expired",
+			token:     "expired",
+			wantErrIs: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := in.introspect(context.Background(), tt.token)
+			if tt.wantErrIs != nil {
+				assert.ErrorIs(t, err, tt.wantErrIs)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIntrospectorCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	server := newTestIntrospection(t)
+	server.setToken("long-lived", map[string]interface{}{
+		"active": true,
+		"sub":    "idntusr-1",
+		"exp":    now.Add(time.Hour).Unix(),
+	})
+	server.setToken("short-lived", map[string]interface{}{
+		"active": true,
+		"sub":    "idntusr-2",
+		"exp":    now.Add(10 * time.Second).Unix(),
+	})
+
+	in := getIntrospector(newTestIntrospectionConfig(t, server.URL))
+	in.now = func() time.Time { return now }
+
+	for _, token := range []string{"long-lived", "short-lived", "long-lived", "short-lived"} {
+		_, err := in.introspect(context.Background(), token)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, server.callCount(), "expected active tokens to be cached")
+
+	// the short-lived token expired, the cache TTL of the long-lived one has not
+	now = now.Add(30 * time.Second)
+
+	for _, token := range []string{"long-lived", "short-lived"} {
+		_, err := in.introspect(context.Background(), token)
+		assert.Equal(t, token == "short-lived", err != nil, "unexpected result for %s", token)
+	}
+
+	assert.Equal(t, 3, server.callCount(), "expected the cache to be bounded by the token expiry")
+
+	_, err := in.introspect(context.Background(), "inactive")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = in.introspect(context.Background(), "inactive")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	assert.Equal(t, 5, server.callCount(), "expected inactive tokens not to be cached")
+
+	// the cache TTL of the long-lived token expired
+	now = now.Add(time.Minute)
+	server.setFailing(true)
+
+	_, err = in.introspect(context.Background(), "long-lived")
+	assert.ErrorIs(t, err, ErrIntrospectionUnavailable)
+}
+
+func TestHandleAuthorizationRequestIntrospection(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:infratographer:test:"+resID.String())
+
+	server := newTestIntrospection(t)
+	server.setToken("opaque", map[string]interface{}{
+		"active":    true,
+		"sub":       "idntusr-1",
+		"client_id": "machine-1",
+		"scope":     "test:read",
+	})
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	jwks := newTestJWKS(t, key.jwk())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.JWT = newTestJWTConfig(t, jwks.URL)
+	cfg.Introspection = newTestIntrospectionConfig(t, server.URL)
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(token string) RequestWrapper {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + token}},
+			params:  map[string]string{"Test_id": resID.String()},
+		}
+	}
+
+	_, err = authorize(h, newReq("revoked"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 0, api.callCount(), "expected inactive tokens not to reach the permissions-api")
+
+	d, err := h.handleAuthorizationRequest(context.Background(), newReq("opaque"))
+	require.NoError(t, err)
+	assert.True(t, d.allowed)
+	assert.Equal(t, "idntusr-1", d.subject)
+	assert.Equal(t, "machine-1", d.clientID)
+	assert.Equal(t, []string{"test:read"}, d.scopes)
+
+	// JWTs are verified against the JWKS rather than introspected
+	allowed, err := authorize(h, newReq(key.sign(t, map[string]interface{}{
+		"iss": cfg.JWT.Issuer,
+		"aud": "porton",
+		"sub": "idntusr-2",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 2, server.callCount())
+}
+
+func TestHandleAuthorizationRequestIntrospectionSubjectClaim(t *testing.T) {
+	t.Parallel()
+
+	server := newTestIntrospection(t)
+	server.setToken("opaque", map[string]interface{}{
+		"active":    true,
+		"sub":       "idntusr-1",
+		"client_id": "machine-1",
+		"tenant":    "tnntten-1",
+	})
+
+	cfg := newTestConfig(t, "http://authz")
+	cfg.AuthorizationService = &AuthzService{
+		Type:         AuthzServiceTypeStatic,
+		PolicyFile:   writeTestPolicy(t, `{"rules": [{"subjects": ["tnntten-1"], "actions": ["test_get"], "resources": ["*"]}]}`),
+		SubjectClaim: "tenant",
+	}
+	cfg.Introspection = newTestIntrospectionConfig(t, server.URL)
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(token string) RequestWrapper {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + token}},
+			params:  map[string]string{"Test_id": uuid.NewString()},
+		}
+	}
+
+	// the subject is read from the introspection response rather than from the token
+	allowed, err := authorize(h, newReq("opaque"))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	_, err = authorize(h, newReq(testJWT(`{"sub":"idntusr-1","tenant":"tnntten-1"}`)))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrJWKSUnavailable is returned when the JWKS holding the token signing keys cannot be fetched
+var ErrJWKSUnavailable = errors.New("JWKS unavailable")
+
+// jwksMinRefreshInterval is the minimum time between two fetches of a JWKS triggered by
+// tokens signed with an unknown key, so that such tokens cannot flood the JWKS endpoint.
+const jwksMinRefreshInterval = 10 * time.Second
+
+// maxJWKSSize is the maximum size of a JWKS document in bytes
+const maxJWKSSize = 1 << 20
+
+// jwk is a JSON web key as found in a JWKS document (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// signingKey is a public key of a JWKS
+type signingKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// keySet is a JWKS cached in memory. The keys are fetched again once they're older than
+// the cache TTL, or when a token is signed with an unknown key, which happens when the
+// signing keys are rotated.
+type keySet struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu   sync.Mutex
+	keys []signingKey
+	// fetchedAt is the time of the last successful fetch
+	fetchedAt time.Time
+	// attemptedAt is the time of the last fetch, successful or not
+	attemptedAt time.Time
+	// fetchErr is the error of the last fetch, if it failed
+	fetchErr error
+	// fetching is the fetch in progress, if any
+	fetching *jwksFetch
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// jwksFetch is a fetch of a JWKS, shared by the callers waiting for it
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// keySets holds the key sets shared by every endpoint using the same JWKS URL
+var keySets = newRegistry[*keySet]("JWKS")
+
+// getKeySet returns the key set of the given JWKS URL, creating it if needed.
+// The settings of the first configuration seen for a URL are used.
+func getKeySet(cfg *JWTConfig) *keySet {
+	settings := struct{ cacheTTL, timeout int }{cfg.JWKSCacheTTL, cfg.JWKSTimeout}
+
+	ks, _ := keySets.get(cfg.JWKSURL.String(), settings, func() (*keySet, error) {
+		return &keySet{
+			url: cfg.JWKSURL.String(),
+			ttl: time.Duration(cfg.JWKSCacheTTL) * time.Millisecond,
+			client: &http.Client{
+				Timeout: time.Duration(cfg.JWKSTimeout) * time.Millisecond,
+			},
+			now: time.Now,
+		}, nil
+	})
+
+	return ks
+}
+
+// find returns the keys matching the given key ID, or every key if kid is empty.
+// The key set is refreshed first when it has expired, and when no key matches.
+// ErrJWKSUnavailable is returned when the keys have never been fetched.
+func (ks *keySet) find(ctx context.Context, kid string) ([]signingKey, error) {
+	ks.mu.Lock()
+	now := ks.now()
+	expired := ks.keys == nil || now.Sub(ks.fetchedAt) >= ks.ttl
+	// failed fetches are not retried before the minimum refresh interval
+	retry := ks.fetchErr == nil || now.Sub(ks.attemptedAt) >= jwksMinRefreshInterval
+	fetchErr := ks.fetchErr
+	ks.mu.Unlock()
+
+	if expired && retry {
+		fetchErr = ks.refresh(ctx)
+	}
+
+	keys, loaded := ks.match(kid)
+	if !loaded {
+		return nil, fetchErr
+	}
+
+	if expired && retry && fetchErr != nil {
+		logger.Error("error refreshing JWKS, using the keys fetched before", "url", ks.url, "error", fetchErr)
+	}
+
+	if len(keys) > 0 {
+		return keys, nil
+	}
+
+	// the signing keys may have been rotated
+	ks.mu.Lock()
+	rotate := ks.now().Sub(ks.attemptedAt) >= jwksMinRefreshInterval
+	ks.mu.Unlock()
+
+	if rotate {
+		if err := ks.refresh(ctx); err != nil {
+			logger.Error("error refreshing JWKS", "url", ks.url, "error", err)
+		}
+	}
+
+	keys, _ = ks.match(kid)
+
+	return keys, nil
+}
+
+// match returns the keys matching the given key ID, or every key if kid is empty, and
+// whether the keys have been fetched
+func (ks *keySet) match(kid string) ([]signingKey, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.keys == nil {
+		return nil, false
+	}
+
+	if kid == "" {
+		return ks.keys, true
+	}
+
+	var keys []signingKey
+
+	for _, k := range ks.keys {
+		if k.kid == kid {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, true
+}
+
+// refresh fetches the keys of the JWKS, or waits for the fetch in progress. The fetch is
+// shared by the concurrent callers and isn't tied to their requests, it's only bounded by
+// the JWKS timeout. Callers stop waiting for it when their context is done.
+func (ks *keySet) refresh(ctx context.Context) error {
+	ks.mu.Lock()
+
+	f := ks.fetching
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		ks.fetching = f
+		ks.attemptedAt = ks.now()
+
+		go ks.fetch(f)
+	}
+
+	ks.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrJWKSUnavailable, ctx.Err())
+	}
+}
+
+// fetch runs the given fetch and records its outcome
+func (ks *keySet) fetch(f *jwksFetch) {
+	keys, err := ks.fetchKeys(context.Background())
+
+	ks.mu.Lock()
+
+	if err == nil {
+		ks.keys = keys
+		ks.fetchedAt = ks.now()
+	}
+
+	ks.fetchErr = err
+	ks.fetching = nil
+
+	ks.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+}
+
+// fetchKeys fetches the keys of the JWKS. Keys which cannot be used to verify signatures
+// are skipped.
+func (ks *keySet) fetchKeys(ctx context.Context) ([]signingKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrJWKSUnavailable, resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: error decoding JWKS: %w", ErrJWKSUnavailable, err)
+	}
+
+	keys := make([]signingKey, 0, len(doc.Keys))
+
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			logger.Warn("skipping invalid JWK", "url", ks.url, "kid", k.Kid, "error", err)
+			continue
+		}
+
+		keys = append(keys, signingKey{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+
+	return keys, nil
+}
+
+// publicKey returns the public key described by the JWK
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, errors.New("invalid exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, errX := decodeBigInt(k.X)
+		y, errY := decodeBigInt(k.Y)
+
+		if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid point")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// register the hash functions used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// ErrInvalidToken is returned when the token is rejected, by porton or by the authorization service
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwtAlgorithms are the supported JWT signing algorithms
+var jwtAlgorithms = map[string]bool{
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+	"PS256": true,
+	"PS384": true,
+	"PS512": true,
+	"ES256": true,
+	"ES384": true,
+	"ES512": true,
+	"EdDSA": true,
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// tokenClaims are the claims of a JWT checked by porton
+type tokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// audience is the aud claim, which is either a single string or a list of strings
+type audience []string
+
+// UnmarshalJSON decodes a single string or a list of strings
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.New("aud should be a string or a list of strings")
+	}
+
+	*a = list
+
+	return nil
+}
+
+// jwtVerifier verifies the signature and the claims of JWTs
+type jwtVerifier struct {
+	cfg  *JWTConfig
+	keys *keySet
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// newJWTVerifier returns a new verifier for the given configuration.
+// It returns nil if JWT verification is not configured.
+func newJWTVerifier(cfg *JWTConfig) *jwtVerifier {
+	if cfg == nil {
+		return nil
+	}
+
+	return &jwtVerifier{
+		cfg:  cfg,
+		keys: getKeySet(cfg),
+		now:  time.Now,
+	}
+}
+
+// verify verifies the given JWT and returns its claims. It returns an error wrapping
+// ErrInvalidToken if the token is not valid, or ErrJWKSUnavailable if the signing keys
+// cannot be fetched.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %w", ErrInvalidToken, err)
+	}
+
+	if !v.algorithmAllowed(header.Alg) {
+		return nil, fmt.Errorf("%w: signing algorithm %q is not allowed", ErrInvalidToken, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrInvalidToken)
+	}
+
+	keys, err := v.keys.find(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+
+	for _, k := range keys {
+		if k.alg != "" && k.alg != header.Alg {
+			continue
+		}
+
+		if verifySignature(header.Alg, k.key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+	}
+
+	var claims tokenClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %w", ErrInvalidToken, err)
+	}
+
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return &claims, nil
+}
+
+// algorithmAllowed reports whether the signing algorithm is configured
+func (v *jwtVerifier) algorithmAllowed(alg string) bool {
+	for _, allowed := range v.cfg.Algorithms {
+		if alg == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateClaims checks the issuer, audience, expiry and not before claims, tolerating
+// the configured clock skew
+func (v *jwtVerifier) validateClaims(claims *tokenClaims) error {
+	if claims.Issuer != v.cfg.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if len(v.cfg.Audiences) > 0 && !claims.Audience.containsAny(v.cfg.Audiences) {
+		return errors.New("unexpected audience")
+	}
+
+	now := v.now()
+	skew := time.Duration(v.cfg.ClockSkew) * time.Millisecond
+
+	if claims.ExpiresAt == nil {
+		return errors.New("missing exp claim")
+	}
+
+	if !now.Before(numericDate(*claims.ExpiresAt).Add(skew)) {
+		return errors.New("token has expired")
+	}
+
+	if claims.NotBefore != nil && now.Add(skew).Before(numericDate(*claims.NotBefore)) {
+		return errors.New("token is not valid yet")
+	}
+
+	return nil
+}
+
+// containsAny reports whether the audience contains any of the given values
+func (a audience) containsAny(values []string) bool {
+	for _, aud := range a {
+		for _, v := range values {
+			if aud == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// numericDate converts a JWT NumericDate, a number of seconds since the epoch, to a time
+func numericDate(secs float64) time.Time {
+	return time.Unix(0, int64(secs*float64(time.Second)))
+}
+
+// decodeJWTPart decodes a base64url encoded JSON part of a JWT into v
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature reports whether sig is a valid signature of signed using the given
+// algorithm and key. It returns false if the key cannot be used with the algorithm.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+
+		return ok && ed25519.Verify(pub, signed, sig)
+	}
+
+	var hash crypto.Hash
+
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+
+		return ok && rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		// the signature is the concatenation of r and s, each the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size || pub.Curve.Params().BitSize != ecdsaBitSizes[hash] {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// ecdsaBitSizes are the curve sizes of the ES256, ES384 and ES512 algorithms, keyed by hash
+var ecdsaBitSizes = map[crypto.Hash]int{
+	crypto.SHA256: 256,
+	crypto.SHA384: 384,
+	crypto.SHA512: 521,
+}
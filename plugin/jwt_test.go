@@ -0,0 +1,500 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWKS is a JWKS server whose keys can be rotated
+type testJWKS struct {
+	*fakeServer
+
+	keys []jwk
+}
+
+func newTestJWKS(t *testing.T, keys ...jwk) *testJWKS {
+	t.Helper()
+
+	s := &testJWKS{
+		keys: keys,
+	}
+
+	s.fakeServer = newFakeServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys})
+	})
+
+	return s
+}
+
+func (s *testJWKS) setKeys(keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = keys
+}
+
+// testSigningKey is a private key used to sign test tokens, with its JWK
+type testSigningKey struct {
+	alg  string
+	kid  string
+	priv crypto.Signer
+}
+
+func newTestSigningKey(t *testing.T, alg, kid string) *testSigningKey {
+	t.Helper()
+
+	var (
+		priv crypto.Signer
+		err  error
+	)
+
+	switch alg {
+	case "RS256", "PS256":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "EdDSA":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported test algorithm %s", alg)
+	}
+
+	require.NoError(t, err)
+
+	return &testSigningKey{alg: alg, kid: kid, priv: priv}
+}
+
+func (k *testSigningKey) jwk() jwk {
+	enc := base64.RawURLEncoding
+
+	switch pub := k.priv.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: k.kid, Use: "sig", N: enc.EncodeToString(pub.N.Bytes()), E: enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+
+		return jwk{Kty: "EC", Kid: k.kid, Crv: pub.Curve.Params().Name, X: enc.EncodeToString(pub.X.FillBytes(make([]byte, size))), Y: enc.EncodeToString(pub.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return jwk{Kty: "OKP", Kid: k.kid, Crv: "Ed25519", X: enc.EncodeToString(pub)}
+	default:
+		panic("unsupported key type")
+	}
+}
+
+// sign returns a JWT with the given claims signed by the key
+func (k *testSigningKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	enc := base64.RawURLEncoding
+
+	header, err := json.Marshal(jwtHeader{Alg: k.alg, Kid: k.kid})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	var sig []byte
+
+	switch priv := k.priv.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(signed))
+	case *ecdsa.PrivateKey:
+		hash := crypto.SHA256
+		if k.alg == "ES384" {
+			hash = crypto.SHA384
+		}
+
+		h := hash.New()
+		h.Write([]byte(signed))
+
+		r, s, err := ecdsa.Sign(rand.Reader, priv, h.Sum(nil))
+		require.NoError(t, err)
+
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case *rsa.PrivateKey:
+		h := crypto.SHA256.New()
+		h.Write([]byte(signed))
+
+		if k.alg == "PS256" {
+			sig, err = rsa.SignPSS(rand.Reader, priv, crypto.SHA256, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, h.Sum(nil))
+		}
+
+		require.NoError(t, err)
+	}
+
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func newTestJWTConfig(t *testing.T, jwksURL string) *JWTConfig {
+	t.Helper()
+
+	return &JWTConfig{
+		JWKSURL:      mustParseURL(t, jwksURL),
+		Issuer:       "https://issuer.example.com",
+		Audiences:    []string{"porton"},
+		Algorithms:   []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"},
+		ClockSkew:    60000,
+		JWKSCacheTTL: 900000,
+		JWKSTimeout:  1000,
+	}
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	keys := map[string]*testSigningKey{}
+	jwks := make([]jwk, 0, 5)
+
+	for _, alg := range []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"} {
+		keys[alg] = newTestSigningKey(t, alg, "key-"+alg)
+		jwks = append(jwks, keys[alg].jwk())
+	}
+
+	server := newTestJWKS(t, jwks...)
+
+	v := newJWTVerifier(newTestJWTConfig(t, server.URL))
+	v.now = func() time.Time { return now }
+
+	validClaims := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"sub": "user-1",
+			"aud": "porton",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+
+		return claims
+	}
+
+	// a key which is not in the JWKS, but has the ID of one which is
+	impostor := newTestSigningKey(t, "RS256", "key-RS256")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "RS256",
+			token: keys["RS256"].sign(t, validClaims(nil)),
+		},
+		{
+			name:  "PS256",
+			token: keys["PS256"].sign(t, validClaims(nil)),
+		},
+		{
+			name:  "ES256",
+			token: keys["ES256"].sign(t, validClaims(nil)),
+		},
+		{
+			name:  "ES384",
+			token: keys["ES384"].sign(t, validClaims(nil)),
+		},
+		{
+			name:  "EdDSA",
+			token: keys["EdDSA"].sign(t, validClaims(nil)),
+		},
+		{
+			name:  "audience list",
+			token: keys["RS256"].sign(t, validClaims(map[string]interface{}{"aud": []string{"other", "porton"}})),
+		},
+		{
+			name:  "expired within clock skew",
+			token: keys["RS256"].sign(t, validClaims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})),
+		},
+		{
+			name:    "expired",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing expiry",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"exp": nil})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:  "not valid yet within clock skew",
+			token: keys["RS256"].sign(t, validClaims(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()})),
+		},
+		{
+			name:    "not valid yet",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong issuer",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"iss": "https://evil.example.com"})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong audience",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"aud": "other"})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing audience",
+			token:   keys["RS256"].sign(t, validClaims(map[string]interface{}{"aud": nil})),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "bad signature",
+			token:   impostor.sign(t, validClaims(nil)),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "algorithm none",
+			token:   testJWT(`{"iss":"https://issuer.example.com","aud":"porton","exp":9999999999}`),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "opaque token",
+			token:   "token",
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			claims, err := v.verify(context.Background(), tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", claims.Subject)
+		})
+	}
+}
+
+func TestJWTVerifierAlgorithmNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	key := newTestSigningKey(t, "ES256", "key-1")
+	server := newTestJWKS(t, key.jwk())
+
+	cfg := newTestJWTConfig(t, server.URL)
+	cfg.Algorithms = []string{"RS256"}
+
+	v := newJWTVerifier(cfg)
+
+	token := key.sign(t, map[string]interface{}{
+		"iss": cfg.Issuer,
+		"aud": "porton",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	_, err := v.verify(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 0, server.callCount(), "expected the JWKS not to be fetched")
+}
+
+func TestJWTVerifierKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	oldKey := newTestSigningKey(t, "RS256", "old")
+	newKey := newTestSigningKey(t, "RS256", "new")
+
+	server := newTestJWKS(t, oldKey.jwk())
+
+	cfg := newTestJWTConfig(t, server.URL)
+	v := newJWTVerifier(cfg)
+
+	now := time.Now()
+	v.keys.now = func() time.Time { return now }
+
+	claims := map[string]interface{}{
+		"iss": cfg.Issuer,
+		"aud": "porton",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	_, err := v.verify(context.Background(), oldKey.sign(t, claims))
+	require.NoError(t, err)
+
+	server.setKeys(oldKey.jwk(), newKey.jwk())
+
+	// unknown keys don't trigger a refresh right after the last one
+	_, err = v.verify(context.Background(), newKey.sign(t, claims))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, server.callCount())
+
+	now = now.Add(jwksMinRefreshInterval)
+
+	_, err = v.verify(context.Background(), newKey.sign(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.callCount())
+
+	// the new key is cached
+	_, err = v.verify(context.Background(), newKey.sign(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.callCount())
+}
+
+func TestJWTVerifierJWKSUnavailable(t *testing.T) {
+	t.Parallel()
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	server := newTestJWKS(t, key.jwk())
+
+	cfg := newTestJWTConfig(t, server.URL)
+	v := newJWTVerifier(cfg)
+
+	now := time.Now()
+	v.keys.now = func() time.Time { return now }
+
+	token := key.sign(t, map[string]interface{}{
+		"iss": cfg.Issuer,
+		"aud": "porton",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	server.setFailing(true)
+
+	_, err := v.verify(context.Background(), token)
+	assert.ErrorIs(t, err, ErrJWKSUnavailable)
+
+	// failed fetches are not retried before the minimum refresh interval, the JWKS is still
+	// unavailable rather than the token invalid
+	_, err = v.verify(context.Background(), token)
+	assert.ErrorIs(t, err, ErrJWKSUnavailable)
+	assert.Equal(t, 1, server.callCount())
+
+	server.setFailing(false)
+	now = now.Add(jwksMinRefreshInterval)
+
+	_, err = v.verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// the keys fetched before are used when the JWKS becomes unavailable
+	server.setFailing(true)
+	now = now.Add(time.Duration(cfg.JWKSCacheTTL) * time.Millisecond)
+
+	_, err = v.verify(context.Background(), token)
+	require.NoError(t, err)
+}
+
+func TestJWTVerifierConcurrentFetch(t *testing.T) {
+	t.Parallel()
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	server := newTestJWKS(t, key.jwk())
+
+	cfg := newTestJWTConfig(t, server.URL)
+	v := newJWTVerifier(cfg)
+
+	token := key.sign(t, map[string]interface{}{
+		"iss": cfg.Issuer,
+		"aud": "porton",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	// the fetch is not cancelled with the request which started it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _ = v.verify(ctx, token)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := v.verify(context.Background(), token)
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, server.callCount(), "expected concurrent requests to share the fetch")
+}
+
+func TestHandleAuthorizationRequestJWT(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:infratographer:test:"+resID.String())
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	server := newTestJWKS(t, key.jwk())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.JWT = newTestJWTConfig(t, server.URL)
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	claims := map[string]interface{}{
+		"iss": cfg.JWT.Issuer,
+		"aud": "porton",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	newReq := func(token string) RequestWrapper {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + token}},
+			params:  map[string]string{"Test_id": resID.String()},
+		}
+	}
+
+	_, err = authorize(h, newReq("token"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	_, err = authorize(h, newReq(key.sign(t, claims)))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	assert.Equal(t, 0, api.callCount(), "expected invalid tokens not to reach the permissions-api")
+
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+	allowed, err := authorize(h, newReq(key.sign(t, claims)))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, api.callCount())
+}
@@ -1,8 +1,26 @@
 package plugin
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 
-var logger Logger = noopLogger{}
+	"golang.org/x/exp/slog"
+)
+
+// logPrefix prefixes the messages written to the logger registered by KrakenD
+const logPrefix = "[PLUGIN: porton]"
+
+// registeredLogger holds the logger registered by KrakenD, if any
+var registeredLogger atomic.Value
+
+// logger is the structured logger of porton. It writes to the logger registered by
+// KrakenD, or to the default slog logger when none is registered. The loggers of the
+// endpoints are derived from it.
+var logger = slog.New(newLoggerHandler())
 
 func (portonRegisterer) RegisterLogger(v interface{}) {
 	l, ok := v.(Logger)
@@ -10,7 +28,9 @@ func (portonRegisterer) RegisterLogger(v interface{}) {
 		fmt.Println("WARNING: logger registration did not succeed.")
 		return
 	}
-	logger = l
+
+	registeredLogger.Store(l)
+	logger = slog.New(newLoggerHandler())
 }
 
 type Logger interface {
@@ -22,12 +42,160 @@ type Logger interface {
 	Fatal(v ...interface{})
 }
 
-// Empty logger implementation
-type noopLogger struct{}
+// getRegisteredLogger returns the logger registered by KrakenD, or nil if none is registered
+func getRegisteredLogger() Logger {
+	l, _ := registeredLogger.Load().(Logger)
+
+	return l
+}
+
+// newLoggerHandler returns the slog handler writing to the logger registered by KrakenD,
+// or the handler of the default slog logger when none is registered.
+func newLoggerHandler() slog.Handler {
+	l := getRegisteredLogger()
+	if l == nil {
+		return slog.Default().Handler()
+	}
+
+	return newRegisteredLoggerHandler(l)
+}
+
+// newRegisteredLoggerHandler returns a slog handler writing to the given registered logger
+func newRegisteredLoggerHandler(l Logger) slog.Handler {
+	h := &registeredLoggerHandler{
+		logger: l,
+		mu:     &sync.Mutex{},
+		buf:    &bytes.Buffer{},
+	}
+
+	// KrakenD adds the time and level of the messages itself
+	h.text = slog.NewTextHandler(h.buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+
+			return a
+		},
+	})
+
+	return h
+}
+
+// registeredLoggerHandler is a slog.Handler adapting structured records onto the variadic
+// Logger registered by KrakenD. Records are formatted as key=value pairs and written at
+// the matching level.
+type registeredLoggerHandler struct {
+	logger Logger
+	text   slog.Handler
+
+	// mu guards buf, which is shared by the handlers derived with WithAttrs and WithGroup
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+// Enabled reports whether the handler handles records at the given level, level
+// filtering is left to the registered logger
+func (h *registeredLoggerHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle formats the record and writes it to the registered logger
+func (h *registeredLoggerHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	h.buf.Reset()
+	err := h.text.Handle(ctx, r)
+	line := strings.TrimSuffix(h.buf.String(), "\n")
+	h.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case r.Level >= slog.LevelError:
+		h.logger.Error(logPrefix, line)
+	case r.Level >= slog.LevelWarn:
+		h.logger.Warning(logPrefix, line)
+	case r.Level >= slog.LevelInfo:
+		h.logger.Info(logPrefix, line)
+	default:
+		h.logger.Debug(logPrefix, line)
+	}
+
+	return nil
+}
 
-func (n noopLogger) Debug(_ ...interface{})    {}
-func (n noopLogger) Info(_ ...interface{})     {}
-func (n noopLogger) Warning(_ ...interface{})  {}
-func (n noopLogger) Error(_ ...interface{})    {}
-func (n noopLogger) Critical(_ ...interface{}) {}
-func (n noopLogger) Fatal(_ ...interface{})    {}
+// WithAttrs returns a handler adding the given attributes to every record
+func (h *registeredLoggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.text = h.text.WithAttrs(attrs)
+
+	return &derived
+}
+
+// WithGroup returns a handler qualifying the keys of the following attributes with the group name
+func (h *registeredLoggerHandler) WithGroup(name string) slog.Handler {
+	derived := *h
+	derived.text = h.text.WithGroup(name)
+
+	return &derived
+}
+
+// levelHandler is a slog.Handler discarding the records below a minimum level
+type levelHandler struct {
+	level slog.Level
+	next  slog.Handler
+}
+
+// Enabled reports whether the record is at or above the minimum level and handled by the next handler
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.next.Enabled(ctx, level)
+}
+
+// Handle passes the record to the next handler
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler adding the given attributes to every record
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler qualifying the keys of the following attributes with the group name
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithGroup(name)}
+}
+
+// newEndpointLogger returns the logger of an endpoint, which discards messages below the
+// configured level and adds the endpoint name to every message.
+func newEndpointLogger(cfg *Config) *slog.Logger {
+	var level slog.Level
+
+	// the level is validated when parsing the configuration
+	_ = level.UnmarshalText([]byte(cfg.LogLevel))
+
+	l := slog.New(&levelHandler{level: level, next: logger.Handler()})
+	if cfg.EndpointName != "" {
+		l = l.With("endpoint", cfg.EndpointName)
+	}
+
+	return l
+}
+
+// logSampler lets one of every n messages through
+type logSampler struct {
+	n     uint64
+	count atomic.Uint64
+}
+
+// sample reports whether the message should be logged
+func (s *logSampler) sample() bool {
+	if s.n <= 1 {
+		return true
+	}
+
+	return (s.count.Add(1)-1)%s.n == 0
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+// testLogger is a Logger recording the messages written to it, prefixed by their level
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) log(level string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, level+" "+fmt.Sprintln(v...))
+}
+
+func (l *testLogger) Debug(v ...interface{})    { l.log("DEBUG", v...) }
+func (l *testLogger) Info(v ...interface{})     { l.log("INFO", v...) }
+func (l *testLogger) Warning(v ...interface{})  { l.log("WARNING", v...) }
+func (l *testLogger) Error(v ...interface{})    { l.log("ERROR", v...) }
+func (l *testLogger) Critical(v ...interface{}) { l.log("CRITICAL", v...) }
+func (l *testLogger) Fatal(v ...interface{})    { l.log("FATAL", v...) }
+
+func TestRegisteredLoggerHandler(t *testing.T) {
+	t.Parallel()
+
+	tl := &testLogger{}
+	log := slog.New(newRegisteredLoggerHandler(tl)).With("endpoint", "get-test")
+
+	log.Debug("debug message")
+	log.Info("request denied", "subject", "user 1")
+	log.Warn("no action configured for method", "method", "POST")
+	log.WithGroup("authz").Error("authorization service failed", "error", "timeout")
+
+	assert.Equal(t, []string{
+		"DEBUG [PLUGIN: porton] msg=\"debug message\" endpoint=get-test\n",
+		"INFO [PLUGIN: porton] msg=\"request denied\" endpoint=get-test subject=\"user 1\"\n",
+		"WARNING [PLUGIN: porton] msg=\"no action configured for method\" endpoint=get-test method=POST\n",
+		"ERROR [PLUGIN: porton] msg=\"authorization service failed\" endpoint=get-test authz.error=timeout\n",
+	}, tl.messages)
+}
+
+func TestLevelHandler(t *testing.T) {
+	t.Parallel()
+
+	tl := &testLogger{}
+	log := slog.New(&levelHandler{level: slog.LevelWarn, next: newRegisteredLoggerHandler(tl)})
+
+	log.Info("dropped")
+	log.With("key", "value").Debug("dropped")
+	log.Warn("kept")
+
+	assert.Len(t, tl.messages, 1)
+	assert.Contains(t, tl.messages[0], "kept")
+}
+
+func TestLogSampler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n    uint64
+		want int
+	}{
+		{n: 1, want: 10},
+		{n: 3, want: 4},
+		{n: 10, want: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(fmt.Sprint(tt.n), func(t *testing.T) {
+			t.Parallel()
+
+			s := &logSampler{n: tt.n}
+			sampled := 0
+
+			for i := 0; i < 10; i++ {
+				if s.sample() {
+					sampled++
+				}
+			}
+
+			assert.Equal(t, tt.want, sampled)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry is the Prometheus registry of the porton metrics. A dedicated registry
+// is used so that porton never conflicts with the metrics of the KrakenD process.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	// decisions counts the authorization decisions, by endpoint, action, result and reason.
+	// The action of requests with several checks is the list of their actions.
+	decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "porton_decisions_total",
+		Help: "Authorization decisions taken by porton.",
+	}, []string{"endpoint", "action", "result", "reason"})
+
+	// authzRequestDuration observes the latency of the calls to the authorizer
+	authzRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "porton_authz_request_duration_seconds",
+		Help:    "Latency of the calls to the authorization service.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// cacheRequests counts the lookups in the decision cache, by result: hit or miss
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "porton_cache_requests_total",
+		Help: "Lookups in the authorization decision cache.",
+	}, []string{"result"})
+
+	// inFlightRequests is the number of requests being authorized
+	inFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "porton_in_flight_requests",
+		Help: "Requests being authorized by porton.",
+	})
+
+	// authzErrorFallbacks counts the requests for which the authorization service failed
+	// and a decision was taken by the on_authz_error policy instead, by policy.
+	authzErrorFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "porton_authz_error_fallbacks_total",
+		Help: "Decisions taken by the on_authz_error policy because the permissions-api failed.",
+	}, []string{"policy"})
+
+	// shadowDecisions counts the decisions taken for requests which are not enforced, keyed
+	// by the decision the request would have been subject to: allowed, denied or error.
+	shadowDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "porton_shadow_decisions_total",
+		Help: "Decisions which were not enforced because of the mode or enforce_percent.",
+	}, []string{"decision"})
+
+	// auditEventsDropped counts the audit events which could not be written, by sink type
+	auditEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "porton_audit_events_dropped_total",
+		Help: "Audit events which could not be written.",
+	}, []string{"sink"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		decisions,
+		authzRequestDuration,
+		cacheRequests,
+		inFlightRequests,
+		authzErrorFallbacks,
+		shadowDecisions,
+		auditEventsDropped,
+	)
+}
+
+// recordDecision counts the decision recorded in the audit event
+func recordDecision(ev *AuditEvent) {
+	actions := make([]string, 0, len(ev.Checks))
+	for _, c := range ev.Checks {
+		actions = append(actions, c.Action)
+	}
+
+	decisions.WithLabelValues(ev.Endpoint, strings.Join(actions, ","), ev.Decision, ev.Reason).Inc()
+}
+
+// metricsServers holds the addresses the metrics are served on
+var metricsServers = newRegistry[struct{}]("metrics")
+
+// serveMetrics serves the metrics on the configured listener, unless they're already
+// served on its address. The path of the first configuration seen for an address is used.
+func serveMetrics(cfg *MetricsConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	_, err := metricsServers.get(cfg.ListenAddress, cfg.Path, func() (struct{}, error) {
+		l, err := net.Listen("tcp", cfg.ListenAddress)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(cfg.Path, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+		go func() {
+			srv := &http.Server{
+				Handler:           mux,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("error serving metrics", "listen_address", l.Addr().String(), "error", err)
+			}
+		}()
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
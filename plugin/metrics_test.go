@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordDecision(t *testing.T) {
+	t.Parallel()
+
+	counter := decisions.WithLabelValues("test-record-decision", "tenant_get,loadbalancer_get", AuditDecisionDenied, ErrorCodeForbidden)
+	before := testutil.ToFloat64(counter)
+
+	recordDecision(&AuditEvent{
+		Endpoint: "test-record-decision",
+		Checks: []AuditCheck{
+			{Action: "tenant_get"},
+			{Action: "loadbalancer_get"},
+		},
+		Decision: AuditDecisionDenied,
+		Reason:   ErrorCodeForbidden,
+	})
+
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+func TestServeMetrics(t *testing.T) {
+	t.Parallel()
+
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	cfg := &MetricsConfig{
+		ListenAddress: addr,
+		Path:          "/metrics",
+	}
+
+	require.NoError(t, serveMetrics(cfg))
+	require.NoError(t, serveMetrics(cfg), "serving the metrics again on the same address should be a no-op")
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "porton_in_flight_requests")
+}
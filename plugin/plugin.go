@@ -8,7 +8,10 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
+
+	"golang.org/x/exp/slog"
 )
 
 /*
@@ -72,41 +75,126 @@ func (r portonRegisterer) RegisterModifiers(f func(
 func (r portonRegisterer) requestModPluginHandle(conf map[string]interface{}) func(interface{}) (interface{}, error) {
 	cfg, err := ParseConfig(conf)
 	if err != nil {
-		logger.Error(err)
+		logger.Error("error parsing config", "error", err)
 		return func(interface{}) (interface{}, error) {
 			return nil, fmt.Errorf("error parsing config: %w", err)
 		}
 	}
 
+	h, err := newAuthzHandler(cfg)
+	if err != nil {
+		logger.Error("error creating authorization handler", "error", err)
+		return func(interface{}) (interface{}, error) {
+			return nil, err
+		}
+	}
+
 	return func(input interface{}) (interface{}, error) {
 		req, ok := input.(RequestWrapper)
 		if !ok {
 			return nil, unkownTypeErr
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.AuthorizationService.Timeout)*time.Millisecond)
+		// The body can only be read once, buffer it so that it's still available to the backend
+		if maxBodySize, ok := cfg.maxBodySize(); ok {
+			buffered, err := newBufferedRequest(req, maxBodySize)
+			if err != nil {
+				h.log.Warn("error buffering request body", "method", req.Method(), "path", req.Path(), "error", err)
+				return nil, errorResponse(req, cfg, err)
+			}
+
+			req = buffered
+		}
+
+		ctx, cancel := context.WithTimeout(contextWithTrace(context.Background(), req), time.Duration(cfg.AuthorizationService.Timeout)*time.Millisecond)
 		defer cancel()
 
-		allowed, err := handleAuthorizationRequest(ctx, req, cfg)
+		ctx, span := startAuthorizeSpan(ctx, req)
+
+		inFlightRequests.Inc()
+		defer inFlightRequests.Dec()
+
+		start := time.Now()
+		d, err := h.handleAuthorizationRequest(ctx, req)
+		enforced := cfg.Mode != ModeShadow && isEnforced(cfg, d, err)
+
+		ev := newAuditEvent(req, cfg, start, d, err, enforced)
+		h.audit.record(ev)
+		recordDecision(ev)
+		endAuthorizeSpan(span, ev, err)
+
+		req = injectHeaders(req, cfg, d, err)
+
+		if !enforced {
+			h.recordShadowDecision(ctx, ev, err)
+			return req, nil
+		}
+
 		if err != nil {
-			logger.Error(err)
-			return nil, HTTPResponseError{
-				Code:         http.StatusInternalServerError,
-				Msg:          "error handling request",
-				HTTPEncoding: HTTPJSONEncoding,
-			}
+			h.log.Log(ctx, errorLogLevel(cfg, err), "authorization failed", append(decisionLogAttrs(ev), "error", err)...)
+			return nil, errorResponse(req, cfg, err)
 		}
 
-		if !allowed {
-			logger.Info("not allowed")
-			return nil, HTTPResponseError{
-				Code:         http.StatusForbidden,
-				Msg:          "not allowed",
-				HTTPEncoding: HTTPJSONEncoding,
-			}
+		if !d.allowed {
+			h.log.Info("request denied", decisionLogAttrs(ev)...)
+			return nil, forbiddenResponse(req, cfg)
+		}
+
+		if h.allowSampler.sample() {
+			h.log.Info("request allowed", decisionLogAttrs(ev)...)
 		}
 
-		logger.Info("allowed")
-		return input, nil
+		return req, nil
 	}
 }
+
+// recordShadowDecision logs and counts the decision taken for a request in shadow mode,
+// or outside of the slice of callers enforce_percent applies to.
+// The request is let through whatever the decision.
+func (h *authzHandler) recordShadowDecision(ctx context.Context, ev *AuditEvent, err error) {
+	shadowDecisions.WithLabelValues(ev.Decision).Inc()
+
+	switch ev.Decision {
+	case AuditDecisionError:
+		h.log.Warn("shadow mode: request would have been rejected", append(decisionLogAttrs(ev), "error", err)...)
+	case AuditDecisionDenied:
+		h.log.Warn("shadow mode: request would have been denied", decisionLogAttrs(ev)...)
+	default:
+		if h.allowSampler.sample() {
+			h.log.Info("shadow mode: request would have been allowed", decisionLogAttrs(ev)...)
+		}
+	}
+}
+
+// decisionLogAttrs returns the attributes logged along with the decision of the audit event
+func decisionLogAttrs(ev *AuditEvent) []any {
+	actions := make([]string, 0, len(ev.Checks))
+	resources := make([]string, 0, len(ev.Checks))
+
+	for _, c := range ev.Checks {
+		actions = append(actions, c.Action)
+		resources = append(resources, c.Resource)
+	}
+
+	return []any{
+		"request_id", ev.RequestID,
+		"method", ev.Method,
+		"path", ev.Path,
+		"subject", ev.Subject,
+		"unverified_subject", ev.UnverifiedSubject,
+		"actions", strings.Join(actions, ","),
+		"resources", strings.Join(resources, ","),
+		"reason", ev.Reason,
+		"latency_ms", ev.LatencyMS,
+	}
+}
+
+// errorLogLevel returns the level errors are logged at: errors caused by the client are
+// logged as warnings.
+func errorLogLevel(cfg *Config, err error) slog.Level {
+	if problemForError(err, cfg).Status < http.StatusInternalServerError {
+		return slog.LevelWarn
+	}
+
+	return slog.LevelError
+}
@@ -0,0 +1,348 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestModPluginHandleMode(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:"+DefaultURNNamespace+":test:"+resID.String())
+
+	tests := []struct {
+		name    string
+		mode    string
+		headers map[string][]string
+		resID   string
+		wantErr bool
+	}{
+		{
+			name:    "enforce allowed",
+			mode:    ModeEnforce,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			resID:   resID.String(),
+		},
+		{
+			name:    "enforce denied",
+			mode:    ModeEnforce,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			resID:   uuid.NewString(),
+			wantErr: true,
+		},
+		{
+			name:    "enforce missing token",
+			mode:    ModeEnforce,
+			resID:   resID.String(),
+			wantErr: true,
+		},
+		{
+			name:    "shadow denied",
+			mode:    ModeShadow,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer token"}},
+			resID:   uuid.NewString(),
+		},
+		{
+			name:  "shadow missing token",
+			mode:  ModeShadow,
+			resID: resID.String(),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": api.URL,
+					},
+					"action":         "test_get",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"mode":           tt.mode,
+				},
+			})
+
+			req := &testRequest{
+				method:  http.MethodGet,
+				headers: tt.headers,
+				params:  map[string]string{"Test_id": tt.resID},
+			}
+
+			got, err := handle(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, req, got)
+		})
+	}
+}
+
+func TestRequestModPluginHandleInjectHeaders(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	urn := "urn:" + DefaultURNNamespace + ":test:" + resID.String()
+	api.allow("test_get", urn)
+
+	token := testJWT(`{"sub":"idntusr-1"}`)
+
+	tests := []struct {
+		name        string
+		mode        string
+		resID       string
+		wantHeaders map[string][]string
+	}{
+		{
+			name:  "allowed",
+			mode:  ModeEnforce,
+			resID: resID.String(),
+			wantHeaders: map[string][]string{
+				AuthorizationHeader:             {"Bearer " + token},
+				"Accept":                        {"application/json"},
+				"X-Infratographer-Subject":      {"idntusr-1"},
+				"X-Infratographer-Resource-Urn": {urn},
+				"X-Infratographer-Action":       {"test_get"},
+			},
+		},
+		{
+			name:  "denied in shadow mode",
+			mode:  ModeShadow,
+			resID: uuid.NewString(),
+			wantHeaders: map[string][]string{
+				AuthorizationHeader: {"Bearer " + token},
+				"Accept":            {"application/json"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": api.URL,
+					},
+					"action":         "test_get",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"mode":           tt.mode,
+					"inject_headers": map[string]interface{}{},
+				},
+			})
+
+			req := &testRequest{
+				method: http.MethodGet,
+				headers: map[string][]string{
+					AuthorizationHeader:        {"Bearer " + token},
+					"Accept":                   {"application/json"},
+					"X-Infratographer-Subject": {"spoofed"},
+					"x-infratographer-action":  {"spoofed"},
+				},
+				params: map[string]string{"Test_id": tt.resID},
+			}
+
+			got, err := handle(req)
+			require.NoError(t, err)
+
+			gotReq, ok := got.(RequestWrapper)
+			require.True(t, ok)
+
+			assert.Equal(t, tt.wantHeaders, gotReq.Headers())
+			assert.Equal(t, req.Params(), gotReq.Params())
+			assert.Equal(t, "spoofed", req.Headers()["X-Infratographer-Subject"][0], "expected the original request not to be modified")
+		})
+	}
+}
+
+func TestRequestModPluginHandleInjectHeadersFallback(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{OnAuthzErrorAllow, OnAuthzErrorAllowStale} {
+		policy := policy
+
+		t.Run(policy, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakePermissionsAPI(t)
+
+			resID := uuid.New()
+			urn := "urn:" + DefaultURNNamespace + ":test:" + resID.String()
+			api.allow("test_get", urn)
+
+			handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+				PluginName: map[string]interface{}{
+					"authz_service": map[string]interface{}{
+						"endpoint": api.URL,
+					},
+					"action":         "test_get",
+					"resource_type":  "test",
+					"resource_param": "test_id",
+					"on_authz_error": policy,
+					"cache": map[string]interface{}{
+						"allow_ttl": 1,
+						"deny_ttl":  1,
+						"stale_ttl": 60000,
+					},
+					"inject_headers": map[string]interface{}{},
+				},
+			})
+
+			newReq := func() *testRequest {
+				return &testRequest{
+					method:  http.MethodGet,
+					headers: map[string][]string{AuthorizationHeader: {"Bearer " + testJWT(`{"sub":"idntusr-1"}`)}},
+					params:  map[string]string{"Test_id": resID.String()},
+				}
+			}
+
+			got, err := handle(newReq())
+			require.NoError(t, err)
+			assert.Equal(t, []string{"idntusr-1"}, got.(RequestWrapper).Headers()["X-Infratographer-Subject"],
+				"expected the subject of a token the permissions-api accepted to be injected")
+
+			time.Sleep(5 * time.Millisecond)
+			api.setFailing(true)
+
+			got, err = handle(newReq())
+			require.NoError(t, err)
+
+			headers := got.(RequestWrapper).Headers()
+			assert.NotContains(t, headers, "X-Infratographer-Subject", "expected the unverified subject not to be injected")
+			assert.Equal(t, []string{urn}, headers["X-Infratographer-Resource-Urn"])
+		})
+	}
+}
+
+func TestRequestModPluginHandleEnforcePercent(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	failing := newFakePermissionsAPI(t)
+	failing.setFailing(true)
+
+	key := newTestSigningKey(t, "RS256", "key-1")
+	jwks := newTestJWKS(t, key.jwk())
+	jwtCfg := newTestJWTConfig(t, jwks.URL)
+
+	signed := key.sign(t, map[string]interface{}{
+		"iss": jwtCfg.Issuer,
+		"aud": "porton",
+		"sub": "idntusr-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name     string
+		endpoint string
+		jwt      bool
+		token    string
+		wantErr  bool
+	}{
+		{
+			name:     "accepted subject not enforced",
+			endpoint: api.URL,
+			token:    testJWT(`{"sub":"idntusr-1"}`),
+		},
+		{
+			name:     "rejected token",
+			endpoint: api.URL,
+			token:    "invalid",
+			wantErr:  true,
+		},
+		{
+			name:     "authorization service failure",
+			endpoint: failing.URL,
+			token:    testJWT(`{"sub":"idntusr-1"}`),
+			wantErr:  true,
+		},
+		{
+			name:     "verified subject not enforced on authorization service failure",
+			endpoint: failing.URL,
+			jwt:      true,
+			token:    signed,
+		},
+		{
+			name:     "forged subject with jwt verification",
+			endpoint: api.URL,
+			jwt:      true,
+			token:    testJWT(`{"sub":"idntusr-1"}`),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pconf := map[string]interface{}{
+				"authz_service": map[string]interface{}{
+					"endpoint": tt.endpoint,
+				},
+				"action":          "test_get",
+				"resource_type":   "test",
+				"resource_param":  "test_id",
+				"enforce_percent": 0,
+				"cache":           map[string]interface{}{},
+				"on_authz_error":  OnAuthzErrorAllowStale,
+			}
+
+			if tt.jwt {
+				pconf["jwt"] = map[string]interface{}{
+					"jwks_url":   jwks.URL,
+					"issuer":     jwtCfg.Issuer,
+					"audiences":  []interface{}{"porton"},
+					"algorithms": []interface{}{"RS256"},
+				}
+			}
+
+			handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+				PluginName: pconf,
+			})
+
+			// the resource is denied, only requests out of enforcement reach the backend
+			req := &testRequest{
+				method:  http.MethodGet,
+				headers: map[string][]string{AuthorizationHeader: {"Bearer " + tt.token}},
+				params:  map[string]string{"Test_id": uuid.NewString()},
+			}
+
+			got, err := handle(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, req, got)
+		})
+	}
+}
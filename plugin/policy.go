@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPolicy is returned when the static policy file is not valid
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// staticPolicy is a policy file granting subjects actions on resources. Requests are denied
+// unless a rule allows them.
+type staticPolicy struct {
+	Rules []policyRule `json:"rules" yaml:"rules"`
+}
+
+// policyRule allows its subjects to perform its actions on its resources. Resources are URNs
+// or URN patterns, in which * matches any sequence of characters, e.g. urn:infratographer:loadbalancer:*.
+type policyRule struct {
+	Subjects  []string `json:"subjects" yaml:"subjects"`
+	Actions   []string `json:"actions" yaml:"actions"`
+	Resources []string `json:"resources" yaml:"resources"`
+}
+
+// allows reports whether a rule of the policy allows the subject to perform the action on the resource
+func (p *staticPolicy) allows(subject, action, resource string) bool {
+	for _, r := range p.Rules {
+		if contains(r.Subjects, subject) && contains(r.Actions, action) && r.matchesResource(resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesResource reports whether the resource URN matches one of the resources of the rule
+func (r *policyRule) matchesResource(resource string) bool {
+	for _, pattern := range r.Resources {
+		// URNs have no slashes, so * matches the rest of the URN. Patterns are validated
+		// when the policy is loaded.
+		if ok, _ := path.Match(pattern, resource); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks that every rule has subjects, actions and valid resource patterns
+func (p *staticPolicy) validate() error {
+	for i, r := range p.Rules {
+		if len(r.Subjects) == 0 || len(r.Actions) == 0 || len(r.Resources) == 0 {
+			return fmt.Errorf("%w: rules[%d] should have subjects, actions and resources", ErrInvalidPolicy, i)
+		}
+
+		for _, pattern := range r.Resources {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%w: rules[%d] has an invalid resource pattern %q", ErrInvalidPolicy, i, pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadPolicy reads and validates the policy file at the given path. Files with a .yaml or
+// .yml extension are decoded as YAML, and other files as JSON.
+func loadPolicy(path string) (*staticPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file: %w", err)
+	}
+
+	var p staticPolicy
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &p)
+	default:
+		err = json.Unmarshal(b, &p)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// policyFile is a policy file loaded in memory. The file is checked for changes at most
+// once per reload interval, when a decision is taken, and reloaded when its modification
+// time or size changed. The policy loaded before is kept when the new one is invalid.
+type policyFile struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.Mutex
+	policy    *staticPolicy
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+
+	// now is used to get the current time, it's overridden in tests
+	now func() time.Time
+}
+
+// policyFiles holds the policy files shared by every endpoint using the same path
+var policyFiles = newRegistry[*policyFile]("policy file")
+
+// getPolicyFile returns the policy file at the given path, loading it if needed.
+// The reload interval of the first configuration seen for a path is used.
+func getPolicyFile(svc *AuthzService) (*policyFile, error) {
+	return policyFiles.get(svc.PolicyFile, svc.PolicyReloadInterval, func() (*policyFile, error) {
+		pf := &policyFile{
+			path:     svc.PolicyFile,
+			interval: time.Duration(svc.PolicyReloadInterval) * time.Millisecond,
+			now:      time.Now,
+		}
+
+		if err := pf.load(); err != nil {
+			return nil, err
+		}
+
+		return pf, nil
+	})
+}
+
+// load reads the policy file, the caller must hold the lock once the policy file is shared
+func (pf *policyFile) load() error {
+	info, err := os.Stat(pf.path)
+	if err != nil {
+		return fmt.Errorf("error reading policy file: %w", err)
+	}
+
+	p, err := loadPolicy(pf.path)
+	if err != nil {
+		return err
+	}
+
+	pf.policy = p
+	pf.modTime = info.ModTime()
+	pf.size = info.Size()
+	pf.checkedAt = pf.now()
+
+	return nil
+}
+
+// current returns the policy, reloading the file first if it changed
+func (pf *policyFile) current() *staticPolicy {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	now := pf.now()
+	if pf.interval <= 0 || now.Sub(pf.checkedAt) < pf.interval {
+		return pf.policy
+	}
+
+	pf.checkedAt = now
+
+	info, err := os.Stat(pf.path)
+	if err != nil {
+		logger.Error("error checking policy file, using the policy loaded before", "path", pf.path, "error", err)
+		return pf.policy
+	}
+
+	if info.ModTime().Equal(pf.modTime) && info.Size() == pf.size {
+		return pf.policy
+	}
+
+	if err := pf.load(); err != nil {
+		logger.Error("error reloading policy file, using the policy loaded before", "path", pf.path, "error", err)
+
+		// don't try again until the file changes
+		pf.modTime = info.ModTime()
+		pf.size = info.Size()
+
+		return pf.policy
+	}
+
+	logger.Info("policy file reloaded", "path", pf.path, "rules", len(pf.policy.Rules))
+
+	return pf.policy
+}
+
+// staticAuthorizer takes decisions with a static policy file. The subject is read from a
+// claim of the token, which is trusted, so it's meant for local development unless tokens
+// are verified with jwt.
+type staticAuthorizer struct {
+	file         *policyFile
+	subjectClaim string
+}
+
+// newStaticAuthorizer returns an authorizer using the policy file of the authorization service
+func newStaticAuthorizer(svc *AuthzService) (*staticAuthorizer, error) {
+	pf, err := getPolicyFile(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectClaim := svc.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = DefaultSubjectClaim
+	}
+
+	return &staticAuthorizer{
+		file:         pf,
+		subjectClaim: subjectClaim,
+	}, nil
+}
+
+// Authorize checks whether the policy allows the subject to perform the action on the resource
+func (a *staticAuthorizer) Authorize(_ context.Context, req *AuthzRequest) (Decision, error) {
+	claims := req.Claims
+	if claims == nil {
+		// tokens are not verified by porton, which is only meant for development
+		claims = unverifiedClaims(req.Token)
+	}
+
+	subject, _ := claims[a.subjectClaim].(string)
+
+	if subject == "" {
+		return Decision{}, nil
+	}
+
+	return Decision{Allowed: a.file.current().allows(subject, req.Action, req.Resource)}, nil
+}
+
+// contains reports whether the values contain v
+func contains(values []string, v string) bool {
+	for _, val := range values {
+		if val == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestPolicy(t *testing.T, policy string) string {
+	t.Helper()
+
+	return writeTestPolicyFile(t, "policy.json", policy)
+}
+
+func writeTestPolicyFile(t *testing.T, name, policy string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(policy), 0o600))
+
+	return path
+}
+
+func TestStaticAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	path := writeTestPolicy(t, `{
+		"rules": [
+			{
+				"subjects": ["user-1", "user-2"],
+				"actions": ["test_get"],
+				"resources": ["urn:infratographer:test:1", "urn:infratographer:other:*"]
+			}
+		]
+	}`)
+
+	a, err := newStaticAuthorizer(&AuthzService{PolicyFile: path})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  *AuthzRequest
+		want bool
+	}{
+		{
+			name: "allowed",
+			req:  &AuthzRequest{Claims: map[string]interface{}{"sub": "user-2"}, Action: "test_get", Resource: "urn:infratographer:test:1"},
+			want: true,
+		},
+		{
+			name: "wildcard",
+			req:  &AuthzRequest{Claims: map[string]interface{}{"sub": "user-1"}, Action: "test_get", Resource: "urn:infratographer:other:2"},
+			want: true,
+		},
+		{
+			name: "other action",
+			req:  &AuthzRequest{Claims: map[string]interface{}{"sub": "user-1"}, Action: "test_delete", Resource: "urn:infratographer:test:1"},
+		},
+		{
+			name: "other resource",
+			req:  &AuthzRequest{Claims: map[string]interface{}{"sub": "user-1"}, Action: "test_get", Resource: "urn:infratographer:test:2"},
+		},
+		{
+			name: "other subject",
+			req:  &AuthzRequest{Claims: map[string]interface{}{"sub": "user-3"}, Action: "test_get", Resource: "urn:infratographer:test:1"},
+		},
+		{
+			name: "no subject",
+			req:  &AuthzRequest{Action: "test_get", Resource: "urn:infratographer:test:1"},
+		},
+		{
+			name: "token not verified by porton",
+			req:  &AuthzRequest{Token: "Bearer " + testJWT(`{"sub":"user-1"}`), Action: "test_get", Resource: "urn:infratographer:test:1"},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := a.Authorize(context.Background(), tt.req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, d.Allowed)
+		})
+	}
+}
+
+func TestStaticAuthorizerInvalidPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		file   string
+		policy string
+	}{
+		{
+			name:   "not json",
+			file:   "policy.json",
+			policy: "rules:",
+		},
+		{
+			name:   "not yaml",
+			file:   "policy.yaml",
+			policy: "rules: [",
+		},
+		{
+			name:   "rule without resources",
+			file:   "policy.json",
+			policy: `{"rules": [{"subjects": ["user-1"], "actions": ["test_get"]}]}`,
+		},
+		{
+			name:   "invalid resource pattern",
+			file:   "policy.json",
+			policy: `{"rules": [{"subjects": ["user-1"], "actions": ["test_get"], "resources": ["urn:["]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newStaticAuthorizer(&AuthzService{PolicyFile: writeTestPolicyFile(t, tt.file, tt.policy)})
+			assert.ErrorIs(t, err, ErrInvalidPolicy)
+		})
+	}
+
+	_, err := newStaticAuthorizer(&AuthzService{PolicyFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestStaticAuthorizerYAML(t *testing.T) {
+	t.Parallel()
+
+	path := writeTestPolicyFile(t, "policy.yaml", `
+rules:
+  - subjects: [user-1]
+    actions: [test_get]
+    resources:
+      - urn:infratographer:test:*
+`)
+
+	a, err := newStaticAuthorizer(&AuthzService{PolicyFile: path})
+	require.NoError(t, err)
+
+	d, err := a.Authorize(context.Background(), &AuthzRequest{Claims: map[string]interface{}{"sub": "user-1"}, Action: "test_get", Resource: "urn:infratographer:test:1"})
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+}
+
+func TestStaticAuthorizerSubjectClaim(t *testing.T) {
+	t.Parallel()
+
+	path := writeTestPolicy(t, `{"rules": [{"subjects": ["client-1"], "actions": ["test_get"], "resources": ["*"]}]}`)
+
+	a, err := newStaticAuthorizer(&AuthzService{PolicyFile: path, SubjectClaim: "client_id"})
+	require.NoError(t, err)
+
+	req := &AuthzRequest{
+		Token:    "Bearer " + testJWT(`{"sub":"user-1","client_id":"client-1"}`),
+		Action:   "test_get",
+		Resource: "urn:infratographer:test:1",
+	}
+
+	d, err := a.Authorize(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+
+	// the claims verified by porton are used rather than those of the token
+	req.Claims = map[string]interface{}{"sub": "user-1", "client_id": "client-2"}
+
+	d, err = a.Authorize(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+}
+
+func TestStaticAuthorizerReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeTestPolicy(t, `{"rules": [{"subjects": ["user-1"], "actions": ["test_get"], "resources": ["*"]}]}`)
+
+	a, err := newStaticAuthorizer(&AuthzService{PolicyFile: path, PolicyReloadInterval: 5000})
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.file.now = func() time.Time { return now }
+
+	allowed := func(subject string) bool {
+		t.Helper()
+
+		d, err := a.Authorize(context.Background(), &AuthzRequest{Claims: map[string]interface{}{"sub": subject}, Action: "test_get", Resource: "urn:infratographer:test:1"})
+		require.NoError(t, err)
+
+		return d.Allowed
+	}
+
+	assert.True(t, allowed("user-1"))
+
+	writePolicy := func(policy string) {
+		t.Helper()
+
+		require.NoError(t, os.WriteFile(path, []byte(policy), 0o600))
+
+		// make sure the change is detected even on file systems with a coarse modification time
+		mtime := now.Add(time.Hour)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	writePolicy(`{"rules": [{"subjects": ["user-2"], "actions": ["test_get"], "resources": ["*"]}]}`)
+
+	now = now.Add(time.Second)
+	assert.False(t, allowed("user-2"), "expected the policy not to be reloaded within the reload interval")
+	assert.True(t, allowed("user-1"))
+
+	now = now.Add(5 * time.Second)
+	assert.True(t, allowed("user-2"), "expected the policy to be reloaded")
+	assert.False(t, allowed("user-1"))
+
+	// invalid policies are ignored
+	writePolicy(`{"rules": [{"subjects": ["user-3"]}]}`)
+
+	now = now.Add(5 * time.Second)
+	assert.True(t, allowed("user-2"), "expected the policy loaded before to be kept")
+	assert.False(t, allowed("user-3"))
+}
+
+func TestStaticAuthorizerDevPolicy(t *testing.T) {
+	t.Parallel()
+
+	// the policy used by make run
+	a, err := newStaticAuthorizer(&AuthzService{PolicyFile: "../tests/data/policy.yaml"})
+	require.NoError(t, err)
+
+	d, err := a.Authorize(context.Background(), &AuthzRequest{Claims: map[string]interface{}{"sub": "dev-user"}, Action: "test_get", Resource: "urn:infratographer:test:1"})
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"reflect"
+	"sync"
+)
+
+// registry holds values shared by every endpoint configured with the same key, such as the
+// circuit breaker of an authorization service endpoint. The value is created from the settings
+// of the first configuration seen for a key, and a warning is logged when the settings of a
+// later configuration differ, as they're ignored.
+type registry[T any] struct {
+	// name describes the values in the warnings
+	name string
+
+	mu      sync.Mutex
+	entries map[string]registryEntry[T]
+}
+
+type registryEntry[T any] struct {
+	value    T
+	settings any
+}
+
+// newRegistry returns an empty registry of the values described by name
+func newRegistry[T any](name string) *registry[T] {
+	return &registry[T]{
+		name:    name,
+		entries: map[string]registryEntry[T]{},
+	}
+}
+
+// get returns the value of the given key, creating it with create if needed. The settings
+// are those the value depends on, they're compared with the settings it was created from.
+// Values are not registered when create fails.
+func (r *registry[T]) get(key string, settings any, create func() (T, error)) (T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[key]; ok {
+		if !reflect.DeepEqual(e.settings, settings) {
+			logger.Warn(r.name+" settings differ between endpoints, using the first ones configured", "key", key)
+		}
+
+		return e.value, nil
+	}
+
+	value, err := create()
+	if err != nil {
+		return value, err
+	}
+
+	r.entries[key] = registryEntry[T]{value: value, settings: settings}
+
+	return value, nil
+}
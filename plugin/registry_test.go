@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := newRegistry[*int]("test")
+	created := 0
+
+	create := func() (*int, error) {
+		created++
+		v := created
+
+		return &v, nil
+	}
+
+	a, err := r.get("a", 1, create)
+	require.NoError(t, err)
+
+	// the settings of the first configuration are used
+	again, err := r.get("a", 2, create)
+	require.NoError(t, err)
+	assert.Same(t, a, again, "expected the value to be shared")
+
+	b, err := r.get("b", 1, create)
+	require.NoError(t, err)
+	assert.NotSame(t, a, b)
+	assert.Equal(t, 2, created)
+
+	errCreate := errors.New("create failed")
+
+	_, err = r.get("c", 1, func() (*int, error) { return nil, errCreate })
+	assert.ErrorIs(t, err, errCreate)
+
+	c, err := r.get("c", 1, create)
+	require.NoError(t, err)
+	assert.Equal(t, 3, *c, "expected failed values not to be registered")
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrRequestBodyTooLarge is returned when the request body exceeds the configured maximum size
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
+// requestWrapper is a RequestWrapper implementation used to hand a modified request
+// back to the krakend pipe. The body is buffered, so that it can be read by porton
+// and still be sent to the backend.
+type requestWrapper struct {
+	params  map[string]string
+	headers map[string][]string
+	body    []byte
+	method  string
+	url     *url.URL
+	query   url.Values
+	path    string
+}
+
+// newBufferedRequest returns a copy of the given request with its body buffered in memory.
+// It returns ErrRequestBodyTooLarge if the body is larger than maxSize bytes.
+func newBufferedRequest(req RequestWrapper, maxSize int) (*requestWrapper, error) {
+	wrapped := &requestWrapper{
+		params:  req.Params(),
+		headers: req.Headers(),
+		method:  req.Method(),
+		url:     req.URL(),
+		query:   req.Query(),
+		path:    req.Path(),
+	}
+
+	body := req.Body()
+	if body == nil {
+		return wrapped, nil
+	}
+	defer body.Close()
+
+	// read one more byte than allowed to detect bodies exceeding the limit
+	buf, err := io.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	if len(buf) > maxSize {
+		return nil, ErrRequestBodyTooLarge
+	}
+
+	wrapped.body = buf
+
+	return wrapped, nil
+}
+
+// Params returns the request path params
+func (r *requestWrapper) Params() map[string]string { return r.params }
+
+// Headers returns the request headers
+func (r *requestWrapper) Headers() map[string][]string { return r.headers }
+
+// Body returns a new reader over the buffered request body
+func (r *requestWrapper) Body() io.ReadCloser {
+	if r.body == nil {
+		return nil
+	}
+
+	return io.NopCloser(bytes.NewReader(r.body))
+}
+
+// Method returns the request method
+func (r *requestWrapper) Method() string { return r.method }
+
+// URL returns the request URL
+func (r *requestWrapper) URL() *url.URL { return r.url }
+
+// Query returns the request query values
+func (r *requestWrapper) Query() url.Values { return r.query }
+
+// Path returns the request path
+func (r *requestWrapper) Path() string { return r.path }
+
+// headersRequest is a RequestWrapper overriding the headers of the wrapped request
+type headersRequest struct {
+	RequestWrapper
+
+	headers map[string][]string
+}
+
+// Headers returns the modified request headers
+func (r *headersRequest) Headers() map[string][]string { return r.headers }
+
+// injectHeaders returns a copy of the request without the configured headers sent by the
+// client, so that they cannot be spoofed, and with these headers set from the decision when
+// the request is allowed. The subject is only set when it's trusted, never on a decision taken
+// per on_authz_error. The headers allowed upstream by an ext_authz authorization service
+// are handled the same way. The request is returned as is if no header is configured.
+func injectHeaders(req RequestWrapper, cfg *Config, d authzDecision, err error) RequestWrapper {
+	var names []string
+
+	if cfg.InjectHeaders != nil {
+		names = append(names, cfg.InjectHeaders.Subject, cfg.InjectHeaders.ResourceURN, cfg.InjectHeaders.Action)
+	}
+
+	if cfg.AuthorizationService != nil {
+		names = append(names, cfg.AuthorizationService.AllowedUpstreamHeaders...)
+	}
+
+	if len(names) == 0 {
+		return req
+	}
+
+	headers := make(map[string][]string, len(req.Headers())+len(names))
+
+	for name, values := range req.Headers() {
+		if !containsHeader(names, name) {
+			headers[name] = values
+		}
+	}
+
+	if err == nil && d.allowed {
+		if cfg.InjectHeaders != nil {
+			if d.subjectTrusted && d.subject != "" {
+				headers[cfg.InjectHeaders.Subject] = []string{d.subject}
+			}
+
+			for _, rc := range d.checks {
+				if rc.action == "" {
+					continue
+				}
+
+				// the values of both headers are in the same order, so that they can be paired
+				headers[cfg.InjectHeaders.ResourceURN] = append(headers[cfg.InjectHeaders.ResourceURN], rc.ref.urn(cfg.URNNamespace))
+				headers[cfg.InjectHeaders.Action] = append(headers[cfg.InjectHeaders.Action], rc.action)
+			}
+		}
+
+		for name, values := range d.headers {
+			headers[name] = values
+		}
+	}
+
+	return &headersRequest{
+		RequestWrapper: req,
+		headers:        headers,
+	}
+}
+
+// containsHeader reports whether the header name is in the given names, ignoring its case
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.infratographer.com/x/urnx"
+)
+
+const (
+	// ResourceSourceParam reads the resource ID from an endpoint path parameter
+	ResourceSourceParam = "param"
+	// ResourceSourceQuery reads the resource ID from a query parameter
+	ResourceSourceQuery = "query"
+	// ResourceSourceHeader reads the resource ID from a request header
+	ResourceSourceHeader = "header"
+	// ResourceSourceBody reads the resource ID from the JSON request body using a JSON pointer
+	ResourceSourceBody = "body"
+)
+
+const (
+	// ResourceIDFormatUUID accepts bare UUIDs as resource IDs
+	ResourceIDFormatUUID = "uuid"
+	// ResourceIDFormatURN accepts full resource URNs as resource IDs
+	ResourceIDFormatURN = "urn"
+	// ResourceIDFormatPrefixed accepts infratographer-style prefixed IDs, e.g. loadbal-7Dbc4VHGb6LLEyPH8XBfA
+	ResourceIDFormatPrefixed = "prefixed"
+)
+
+var (
+	// ErrInvalidResourceID is returned when the resource ID is not in any of the accepted formats
+	ErrInvalidResourceID = errors.New("resource ID is not in a supported format")
+	// ErrInvalidResourceURN is returned when the resource ID is not a valid URN for the resource type
+	ErrInvalidResourceURN = errors.New("resource ID is not a valid URN for the resource type")
+	// ErrInvalidResourcePrefixedID is returned when the resource ID is not a valid prefixed ID for the resource type
+	ErrInvalidResourcePrefixedID = errors.New("resource ID is not a valid prefixed ID for the resource type")
+)
+
+// prefixedIDSuffixRegex matches the part of a prefixed ID following the prefix
+var prefixedIDSuffixRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// resourceRef identifies a resource by its type and ID
+type resourceRef struct {
+	resourceType string
+	id           string
+}
+
+// urn returns the URN of the resource in the given namespace
+func (r resourceRef) urn(namespace string) string {
+	return fmt.Sprintf("urn:%s:%s:%s", strings.ToLower(namespace), strings.ToLower(r.resourceType), r.id)
+}
+
+// resourceIDParser parses a resource ID of one format into a resourceRef
+type resourceIDParser func(cfg *Config, check *Check, id string) (resourceRef, error)
+
+// resourceIDParsers holds the parsers of the supported resource ID formats
+var resourceIDParsers = map[string]resourceIDParser{
+	ResourceIDFormatUUID:     parseUUIDResourceID,
+	ResourceIDFormatURN:      parseURNResourceID,
+	ResourceIDFormatPrefixed: parsePrefixedResourceID,
+}
+
+// parseResourceID parses the resource ID using the formats configured for the check, in order.
+// The first format that accepts the ID wins.
+func parseResourceID(cfg *Config, check *Check, id string) (resourceRef, error) {
+	var firstErr error
+
+	for _, format := range check.ResourceIDFormats {
+		ref, err := resourceIDParsers[format](cfg, check, id)
+		if err == nil {
+			return ref, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(check.ResourceIDFormats) == 1 {
+		return resourceRef{}, firstErr
+	}
+
+	return resourceRef{}, ErrInvalidResourceID
+}
+
+// parseUUIDResourceID parses a bare UUID, which is a resource of the resource type of the check
+func parseUUIDResourceID(_ *Config, check *Check, id string) (resourceRef, error) {
+	resUUID, err := uuid.Parse(id)
+	if err != nil {
+		return resourceRef{}, ErrInvalidResourceUUID
+	}
+
+	return resourceRef{
+		resourceType: check.urnResourceType(),
+		id:           resUUID.String(),
+	}, nil
+}
+
+// parseURNResourceID parses a full URN. The URN must be of the resource type of the check
+// and in one of the configured namespaces.
+func parseURNResourceID(cfg *Config, check *Check, id string) (resourceRef, error) {
+	urn, err := urnx.Parse(id)
+	if err != nil {
+		return resourceRef{}, fmt.Errorf("%w: %v", ErrInvalidResourceURN, err)
+	}
+
+	if !strings.EqualFold(urn.ResourceType, check.urnResourceType()) {
+		return resourceRef{}, fmt.Errorf("%w: unexpected resource type %q", ErrInvalidResourceURN, urn.ResourceType)
+	}
+
+	if !strings.EqualFold(urn.Namespace, cfg.URNNamespace) && !strings.EqualFold(urn.Namespace, cfg.URNCompatNamespace) {
+		return resourceRef{}, fmt.Errorf("%w: unexpected namespace %q", ErrInvalidResourceURN, urn.Namespace)
+	}
+
+	return resourceRef{
+		resourceType: check.urnResourceType(),
+		id:           urn.ResourceID.String(),
+	}, nil
+}
+
+// parsePrefixedResourceID parses a prefixed ID of the form <prefix>-<id>. The prefix must be
+// mapped to the resource type of the check.
+func parsePrefixedResourceID(_ *Config, check *Check, id string) (resourceRef, error) {
+	prefix, suffix, ok := strings.Cut(id, "-")
+	if !ok || !prefixedIDSuffixRegex.MatchString(suffix) {
+		return resourceRef{}, ErrInvalidResourcePrefixedID
+	}
+
+	resourceType, ok := check.ResourceIDPrefixes[prefix]
+	if !ok {
+		return resourceRef{}, fmt.Errorf("%w: unknown prefix %q", ErrInvalidResourcePrefixedID, prefix)
+	}
+
+	if !strings.EqualFold(resourceType, check.urnResourceType()) {
+		return resourceRef{}, fmt.Errorf("%w: prefix %q is of resource type %q", ErrInvalidResourcePrefixedID, prefix, resourceType)
+	}
+
+	return resourceRef{
+		resourceType: resourceType,
+		id:           id,
+	}, nil
+}
+
+// getResourceIDFromSource returns the resource ID from the request location described by src
+func getResourceIDFromSource(req RequestWrapper, src *ResourceSource) string {
+	switch src.Type {
+	case ResourceSourceQuery:
+		if req.Query() == nil {
+			return ""
+		}
+
+		return req.Query().Get(src.Name)
+	case ResourceSourceHeader:
+		if val := getHeader(req, textproto.CanonicalMIMEHeaderKey(src.Name)); val != "" {
+			return val
+		}
+
+		return getHeader(req, src.Name)
+	case ResourceSourceBody:
+		return getBodyField(req, src.Name)
+	default:
+		return getResourceID(req, src.Name)
+	}
+}
+
+// getBodyField returns the string found at the given JSON pointer in the request body
+func getBodyField(req RequestWrapper, pointer string) string {
+	body := req.Body()
+	if body == nil {
+		return ""
+	}
+	defer body.Close()
+
+	var doc interface{}
+	if err := json.NewDecoder(body).Decode(&doc); err != nil {
+		logger.Debug("error decoding request body", "error", err)
+		return ""
+	}
+
+	val, ok := resolveJSONPointer(doc, pointer)
+	if !ok {
+		return ""
+	}
+
+	str, _ := val.(string)
+
+	return str
+}
+
+// resolveJSONPointer resolves the RFC 6901 JSON pointer against the decoded document
+func resolveJSONPointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	cur := doc
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+
+			cur = node[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// isValidJSONPointer returns whether the given string is a JSON pointer referencing
+// a value inside the document
+func isValidJSONPointer(pointer string) bool {
+	return strings.HasPrefix(pointer, "/")
+}
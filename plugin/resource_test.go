@@ -0,0 +1,232 @@
+package plugin
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetResourceIDFromSource(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"parent": {"id": "body-id", "a/b": "escaped-id"}, "items": ["first"], "count": 1}`
+
+	newReq := func() *testRequest {
+		return &testRequest{
+			params:  map[string]string{"Tenant_id": "param-id"},
+			headers: map[string][]string{"X-Tenant-Id": {"header-id"}},
+			query:   url.Values{"tenant_id": {"query-id"}},
+			body:    io.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	tests := []struct {
+		name string
+		src  *ResourceSource
+		want string
+	}{
+		{
+			name: "path param",
+			src:  &ResourceSource{Type: ResourceSourceParam, Name: "tenant_id"},
+			want: "param-id",
+		},
+		{
+			name: "query param",
+			src:  &ResourceSource{Type: ResourceSourceQuery, Name: "tenant_id"},
+			want: "query-id",
+		},
+		{
+			name: "missing query param",
+			src:  &ResourceSource{Type: ResourceSourceQuery, Name: "other"},
+			want: "",
+		},
+		{
+			name: "header",
+			src:  &ResourceSource{Type: ResourceSourceHeader, Name: "x-tenant-id"},
+			want: "header-id",
+		},
+		{
+			name: "body field",
+			src:  &ResourceSource{Type: ResourceSourceBody, Name: "/parent/id"},
+			want: "body-id",
+		},
+		{
+			name: "escaped body field",
+			src:  &ResourceSource{Type: ResourceSourceBody, Name: "/parent/a~1b"},
+			want: "escaped-id",
+		},
+		{
+			name: "body array element",
+			src:  &ResourceSource{Type: ResourceSourceBody, Name: "/items/0"},
+			want: "first",
+		},
+		{
+			name: "body field is not a string",
+			src:  &ResourceSource{Type: ResourceSourceBody, Name: "/count"},
+			want: "",
+		},
+		{
+			name: "missing body field",
+			src:  &ResourceSource{Type: ResourceSourceBody, Name: "/parent/missing"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, getResourceIDFromSource(newReq(), tt.src))
+		})
+	}
+}
+
+func TestNewBufferedRequest(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"parent": {"id": "body-id"}}`
+
+	req := &testRequest{
+		method: "POST",
+		path:   "/test",
+		body:   io.NopCloser(strings.NewReader(body)),
+	}
+
+	buffered, err := newBufferedRequest(req, 1024)
+	require.NoError(t, err)
+
+	assert.Equal(t, "body-id", getResourceIDFromSource(buffered, &ResourceSource{Type: ResourceSourceBody, Name: "/parent/id"}))
+
+	// the body must still be readable after porton read it
+	got, err := io.ReadAll(buffered.Body())
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+	assert.Equal(t, "POST", buffered.Method())
+	assert.Equal(t, "/test", buffered.Path())
+
+	_, err = newBufferedRequest(&testRequest{body: io.NopCloser(strings.NewReader(body))}, 10)
+	assert.ErrorIs(t, err, ErrRequestBodyTooLarge)
+
+	empty, err := newBufferedRequest(&testRequest{}, 10)
+	require.NoError(t, err)
+	assert.Nil(t, empty.Body())
+}
+
+func TestParseResourceID(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+
+	cfg := &Config{
+		URNNamespace:       "infratographer",
+		URNCompatNamespace: "infratrographer",
+	}
+
+	check := &Check{
+		ResourceType:      "loadbalancer",
+		ResourceIDFormats: []string{ResourceIDFormatUUID, ResourceIDFormatURN, ResourceIDFormatPrefixed},
+		ResourceIDPrefixes: map[string]string{
+			"loadbal": "loadbalancer",
+			"tnntten": "tenant",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		check   *Check
+		id      string
+		wantURN string
+		wantErr error
+	}{
+		{
+			name:    "uuid",
+			check:   check,
+			id:      id.String(),
+			wantURN: "urn:infratographer:loadbalancer:" + id.String(),
+		},
+		{
+			name:    "urn",
+			check:   check,
+			id:      "urn:infratographer:loadbalancer:" + id.String(),
+			wantURN: "urn:infratographer:loadbalancer:" + id.String(),
+		},
+		{
+			name:    "urn in compat namespace",
+			check:   check,
+			id:      "urn:infratrographer:loadbalancer:" + id.String(),
+			wantURN: "urn:infratographer:loadbalancer:" + id.String(),
+		},
+		{
+			name:    "prefixed",
+			check:   check,
+			id:      "loadbal-7Dbc4VHGb6LLEyPH8XBfA",
+			wantURN: "urn:infratographer:loadbalancer:loadbal-7Dbc4VHGb6LLEyPH8XBfA",
+		},
+		{
+			name:    "urn of another resource type",
+			check:   check,
+			id:      "urn:infratographer:tenant:" + id.String(),
+			wantErr: ErrInvalidResourceID,
+		},
+		{
+			name:    "urn in another namespace",
+			check:   check,
+			id:      "urn:other:loadbalancer:" + id.String(),
+			wantErr: ErrInvalidResourceID,
+		},
+		{
+			name:    "prefixed id of another resource type",
+			check:   check,
+			id:      "tnntten-7Dbc4VHGb6LLEyPH8XBfA",
+			wantErr: ErrInvalidResourceID,
+		},
+		{
+			name:    "unknown prefix",
+			check:   check,
+			id:      "unknown-7Dbc4VHGb6LLEyPH8XBfA",
+			wantErr: ErrInvalidResourceID,
+		},
+		{
+			name: "only uuid accepted",
+			check: &Check{
+				ResourceType:      "loadbalancer",
+				ResourceIDFormats: []string{ResourceIDFormatUUID},
+			},
+			id:      "urn:infratographer:loadbalancer:" + id.String(),
+			wantErr: ErrInvalidResourceUUID,
+		},
+		{
+			name: "only urn accepted",
+			check: &Check{
+				ResourceType:      "loadbalancer",
+				ResourceIDFormats: []string{ResourceIDFormatURN},
+			},
+			id:      id.String(),
+			wantErr: ErrInvalidResourceURN,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ref, err := parseResourceID(cfg, tt.check, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantURN, ref.urn(cfg.URNNamespace))
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	authclientv1 "go.infratographer.com/permissions-api/pkg/client/v1"
+)
+
+const (
+	// WWWAuthenticateHeader is the name of the header telling clients how to authenticate
+	WWWAuthenticateHeader = "WWW-Authenticate"
+	// HTTPProblemJSONEncoding is the RFC 7807 problem details JSON encoding
+	HTTPProblemJSONEncoding = "application/problem+json"
+	// ProblemTypePrefix is the prefix of the type URI of the problems returned by porton
+	ProblemTypePrefix = "urn:infratographer:porton:problem:"
+)
+
+// Stable, machine-readable codes of the errors returned by porton. They are the keys
+// of the error_messages configuration.
+const (
+	ErrorCodeMissingToken        = "missing_token"
+	ErrorCodeInvalidToken        = "invalid_token"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeInsufficientScope   = "insufficient_scope"
+	ErrorCodeMissingResourceID   = "missing_resource_id"
+	ErrorCodeInvalidResourceID   = "invalid_resource_id"
+	ErrorCodeRequestBodyTooLarge = "request_body_too_large"
+	ErrorCodeAuthzUnavailable    = "authz_unavailable"
+	ErrorCodeAuthzTimeout        = "authz_timeout"
+	ErrorCodeAuthzBadResponse    = "authz_bad_response"
+	ErrorCodeInternalError       = "internal_error"
+)
+
+// errorCodes holds every error code, it's used to validate the error_messages configuration
+var errorCodes = map[string]bool{
+	ErrorCodeMissingToken:        true,
+	ErrorCodeInvalidToken:        true,
+	ErrorCodeForbidden:           true,
+	ErrorCodeInsufficientScope:   true,
+	ErrorCodeMissingResourceID:   true,
+	ErrorCodeInvalidResourceID:   true,
+	ErrorCodeRequestBodyTooLarge: true,
+	ErrorCodeAuthzUnavailable:    true,
+	ErrorCodeAuthzTimeout:        true,
+	ErrorCodeAuthzBadResponse:    true,
+	ErrorCodeInternalError:       true,
+}
+
+// Problem is the RFC 7807 problem details body of the error responses returned by porton
+type Problem struct {
+	// Type is a URI identifying the problem type
+	Type string `json:"type"`
+	// Title is a short summary of the problem type
+	Title string `json:"title"`
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+	// Detail is a human-readable explanation of this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Code is the stable, machine-readable error code
+	Code string `json:"code"`
+	// RequestID is the ID of the request, if known
+	RequestID string `json:"request_id,omitempty"`
+
+	headers map[string][]string
+}
+
+// newProblem returns a new problem with the given status, error code and default detail
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   ProblemTypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// response renders the problem as the error returned to the krakend pipe, applying
+// the custom error messages and the request ID.
+func (p *Problem) response(req RequestWrapper, cfg *Config) HTTPResponseError {
+	if msg, ok := cfg.ErrorMessages[p.Code]; ok {
+		p.Detail = msg
+	}
+
+	if req != nil {
+		p.RequestID = getRequestID(req, cfg)
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		logger.Error("error encoding problem", "error", err)
+
+		body = []byte(p.Detail)
+	}
+
+	return HTTPResponseError{
+		Code:         p.Status,
+		Msg:          string(body),
+		HTTPEncoding: HTTPProblemJSONEncoding,
+		HTTPHeaders:  p.headers,
+	}
+}
+
+// getRequestID returns the ID of the request from the configured request ID header
+func getRequestID(req RequestWrapper, cfg *Config) string {
+	if cfg.RequestIDHeader == "" {
+		return ""
+	}
+
+	if id := getHeader(req, textproto.CanonicalMIMEHeaderKey(cfg.RequestIDHeader)); id != "" {
+		return id
+	}
+
+	return getHeader(req, cfg.RequestIDHeader)
+}
+
+// forbiddenResponse returns the HTTP response sent to the client when the request is denied
+func forbiddenResponse(req RequestWrapper, cfg *Config) HTTPResponseError {
+	return newProblem(http.StatusForbidden, ErrorCodeForbidden, "not allowed").response(req, cfg)
+}
+
+// errorResponse returns the HTTP response sent to the client for an error that
+// occurred while handling the request.
+func errorResponse(req RequestWrapper, cfg *Config, err error) HTTPResponseError {
+	return problemForError(err, cfg).response(req, cfg)
+}
+
+// problemForError returns the problem describing the given error
+func problemForError(err error, cfg *Config) *Problem {
+	var cbErr *circuitOpenError
+
+	switch {
+	case errors.Is(err, ErrNoValidToken):
+		p := newProblem(http.StatusUnauthorized, ErrorCodeMissingToken, "missing or invalid token")
+		p.headers = map[string][]string{
+			WWWAuthenticateHeader: {"Bearer"},
+		}
+
+		return p
+	case errors.Is(err, ErrInvalidToken):
+		p := newProblem(http.StatusUnauthorized, ErrorCodeInvalidToken, "invalid token")
+		p.headers = map[string][]string{
+			WWWAuthenticateHeader: {`Bearer error="invalid_token"`},
+		}
+
+		return p
+	case errors.Is(err, ErrInsufficientScope):
+		p := newProblem(http.StatusForbidden, ErrorCodeInsufficientScope, "insufficient scope")
+		p.headers = map[string][]string{
+			WWWAuthenticateHeader: {fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(cfg.RequiredScopes, " "))},
+		}
+
+		return p
+	case errors.Is(err, ErrNoValidResourceID):
+		return newProblem(cfg.InvalidResourceStatus, ErrorCodeMissingResourceID, "missing resource ID")
+	case errors.Is(err, ErrInvalidResourceUUID),
+		errors.Is(err, ErrInvalidResourceID),
+		errors.Is(err, ErrInvalidResourceURN),
+		errors.Is(err, ErrInvalidResourcePrefixedID):
+		detail := "invalid resource ID"
+		if cfg.InvalidResourceStatus == http.StatusNotFound {
+			detail = "resource not found"
+		}
+
+		return newProblem(cfg.InvalidResourceStatus, ErrorCodeInvalidResourceID, detail)
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		return newProblem(http.StatusRequestEntityTooLarge, ErrorCodeRequestBodyTooLarge, "request body too large")
+	case errors.As(err, &cbErr):
+		p := newProblem(http.StatusServiceUnavailable, ErrorCodeAuthzUnavailable, "authorization service unavailable")
+		p.headers = map[string][]string{
+			"Retry-After": {retryAfterSeconds(cbErr.retryAfter)},
+		}
+
+		return p
+	case errors.Is(err, context.DeadlineExceeded):
+		return newProblem(http.StatusGatewayTimeout, ErrorCodeAuthzTimeout, "authorization service timed out")
+	case errors.Is(err, ErrAuthzRequestRejected):
+		return newProblem(http.StatusForbidden, ErrorCodeForbidden, "request rejected by authorization service")
+	case errors.Is(err, authclientv1.ErrBadResponse), errors.Is(err, ErrBadAuthzResponse):
+		return newProblem(http.StatusBadGateway, ErrorCodeAuthzBadResponse, "bad response from authorization service")
+	case errors.Is(err, ErrCheckingPermissions), errors.Is(err, ErrJWKSUnavailable),
+		errors.Is(err, ErrIntrospectionUnavailable):
+		return newProblem(http.StatusServiceUnavailable, ErrorCodeAuthzUnavailable, "authorization service unavailable")
+	default:
+		return newProblem(http.StatusInternalServerError, ErrorCodeInternalError, "error handling request")
+	}
+}
+
+// retryAfterSeconds formats the duration as the number of seconds of a Retry-After header,
+// rounding up so that clients don't retry too early.
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+
+	return strconv.FormatInt(secs, 10)
+}
@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authclientv1 "go.infratographer.com/permissions-api/pkg/client/v1"
+)
+
+func TestErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{InvalidResourceStatus: http.StatusBadRequest}
+	notFoundCfg := &Config{InvalidResourceStatus: http.StatusNotFound}
+
+	tests := []struct {
+		name        string
+		cfg         *Config
+		err         error
+		wantCode    int
+		wantHeaders map[string][]string
+	}{
+		{
+			name:        "missing token",
+			cfg:         cfg,
+			err:         ErrNoValidToken,
+			wantCode:    http.StatusUnauthorized,
+			wantHeaders: map[string][]string{"WWW-Authenticate": {"Bearer"}},
+		},
+		{
+			name:        "invalid token",
+			cfg:         cfg,
+			err:         fmt.Errorf("%w: token has expired", ErrInvalidToken),
+			wantCode:    http.StatusUnauthorized,
+			wantHeaders: map[string][]string{"WWW-Authenticate": {`Bearer error="invalid_token"`}},
+		},
+		{
+			name:     "jwks unavailable",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: unexpected status code 500", ErrJWKSUnavailable),
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "introspection unavailable",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: unexpected status code 500", ErrIntrospectionUnavailable),
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:        "insufficient scope",
+			cfg:         &Config{RequiredScopes: []string{"test:read", "test:list"}},
+			err:         fmt.Errorf("%w: missing test:list", ErrInsufficientScope),
+			wantCode:    http.StatusForbidden,
+			wantHeaders: map[string][]string{"WWW-Authenticate": {`Bearer error="insufficient_scope", scope="test:read test:list"`}},
+		},
+		{
+			name:     "missing resource id",
+			cfg:      cfg,
+			err:      ErrNoValidResourceID,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid resource id as not found",
+			cfg:      notFoundCfg,
+			err:      ErrInvalidResourceUUID,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "invalid urn",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: unexpected resource type", ErrInvalidResourceURN),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "body too large",
+			cfg:      cfg,
+			err:      ErrRequestBodyTooLarge,
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "circuit open",
+			cfg:         cfg,
+			err:         &circuitOpenError{retryAfter: 1500 * time.Millisecond},
+			wantCode:    http.StatusServiceUnavailable,
+			wantHeaders: map[string][]string{"Retry-After": {"2"}},
+		},
+		{
+			name:     "timeout",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, context.DeadlineExceeded),
+			wantCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:     "bad response",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, authclientv1.ErrBadResponse),
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			name:        "token rejected by authz service",
+			cfg:         cfg,
+			err:         fmt.Errorf("%w: %w", ErrCheckingPermissions, &authzStatusError{code: http.StatusUnauthorized}),
+			wantCode:    http.StatusUnauthorized,
+			wantHeaders: map[string][]string{"WWW-Authenticate": {`Bearer error="invalid_token"`}},
+		},
+		{
+			name:     "request rejected by authz service",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, &authzStatusError{code: http.StatusBadRequest}),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "authz service error",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, &authzStatusError{code: http.StatusInternalServerError}),
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "bad decision response",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, ErrBadAuthzResponse),
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			name:     "unreachable",
+			cfg:      cfg,
+			err:      fmt.Errorf("%w: %w", ErrCheckingPermissions, errors.New("connection refused")),
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "unknown error",
+			cfg:      cfg,
+			err:      errors.New("boom"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := errorResponse(nil, tt.cfg, tt.err)
+			assert.Equal(t, tt.wantCode, resp.StatusCode())
+			assert.Equal(t, tt.wantHeaders, resp.Headers())
+			assert.Equal(t, HTTPProblemJSONEncoding, resp.Encoding())
+
+			var p Problem
+			require.NoError(t, json.Unmarshal([]byte(resp.Error()), &p))
+			assert.Equal(t, tt.wantCode, p.Status)
+			assert.Equal(t, ProblemTypePrefix+p.Code, p.Type)
+			assert.NotEmpty(t, p.Title)
+			assert.NotEmpty(t, p.Detail)
+		})
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		RequestIDHeader: DefaultRequestIDHeader,
+		ErrorMessages: map[string]string{
+			ErrorCodeForbidden: "you may not manage this load balancer",
+		},
+	}
+
+	req := &testRequest{
+		headers: map[string][]string{"X-Request-Id": {"req-1234"}},
+	}
+
+	resp := forbiddenResponse(req, cfg)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+	var p Problem
+	require.NoError(t, json.Unmarshal([]byte(resp.Error()), &p))
+
+	assert.Equal(t, Problem{
+		Type:      "urn:infratographer:porton:problem:forbidden",
+		Title:     "Forbidden",
+		Status:    http.StatusForbidden,
+		Detail:    "you may not manage this load balancer",
+		Code:      ErrorCodeForbidden,
+		RequestID: "req-1234",
+	}, p)
+
+	resp = errorResponse(req, cfg, ErrNoValidToken)
+
+	p = Problem{}
+	require.NoError(t, json.Unmarshal([]byte(resp.Error()), &p))
+	assert.Equal(t, "missing or invalid token", p.Detail, "expected default detail for errors without custom message")
+	assert.Equal(t, ErrorCodeMissingToken, p.Code)
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "1", retryAfterSeconds(0))
+	assert.Equal(t, "1", retryAfterSeconds(200*time.Millisecond))
+	assert.Equal(t, "2", retryAfterSeconds(1500*time.Millisecond))
+	assert.Equal(t, "10", retryAfterSeconds(10*time.Second))
+}
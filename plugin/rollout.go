@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// isEnforced returns whether the decision taken for the request is enforced, according to
+// the enforce_percent setting. Callers are bucketed deterministically by their subject, so
+// that a caller is either always or never subject to enforcement for a given percentage.
+// Only trusted subjects are bucketed, those verified by porton or accepted by the
+// permissions-api: requests without one, and requests with a missing or invalid token, are
+// always enforced so that callers can't choose their bucket.
+func isEnforced(cfg *Config, d authzDecision, err error) bool {
+	if cfg.EnforcePercent >= 100 {
+		return true
+	}
+
+	if errors.Is(err, ErrNoValidToken) || errors.Is(err, ErrInvalidToken) {
+		return true
+	}
+
+	if cfg.EnforcePercentBy == EnforcePercentByRandom {
+		return rand.Intn(100) < cfg.EnforcePercent
+	}
+
+	if !(d.subjectVerified || d.subjectTrusted) || d.subject == "" {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(d.subject))
+
+	return int(h.Sum32()%100) < cfg.EnforcePercent
+}
+
+// bearerToken returns the token of the given Authorization header value, without its scheme
+func bearerToken(authHeader string) string {
+	if scheme, token, ok := strings.Cut(authHeader, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return authHeader
+}
+
+// tokenSubject returns the subject of the bearer token in the given Authorization header
+// value, or an empty string if the token is not a JWT. The token is not verified: the
+// subject must only be used where a caller choosing its value is harmless.
+func tokenSubject(authHeader string) string {
+	sub, _ := unverifiedClaims(authHeader)["sub"].(string)
+
+	return sub
+}
+
+// unverifiedClaims returns the claims of the bearer token in the given Authorization header
+// value, or nil if the token is not a JWT. The token is not verified.
+func unverifiedClaims(authHeader string) map[string]interface{} {
+	parts := strings.Split(bearerToken(authHeader), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+
+	return claims
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestTokenSubject(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{
+			name:   "jwt",
+			header: "Bearer " + testJWT(`{"sub":"user-1"}`),
+			want:   "user-1",
+		},
+		{
+			name:   "jwt without subject",
+			header: "Bearer " + testJWT(`{"iss":"issuer"}`),
+			want:   "",
+		},
+		{
+			name:   "opaque token",
+			header: "bearer opaque",
+			want:   "",
+		},
+		{
+			name:   "empty",
+			header: "",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tokenSubject(tt.header))
+		})
+	}
+}
+
+func TestIsEnforced(t *testing.T) {
+	t.Parallel()
+
+	verified := func(subject string) authzDecision {
+		return authzDecision{subject: subject, subjectVerified: true}
+	}
+
+	t.Run("bounds", func(t *testing.T) {
+		t.Parallel()
+
+		d := verified("user-1")
+
+		assert.True(t, isEnforced(&Config{EnforcePercent: 100, EnforcePercentBy: EnforcePercentBySubject}, d, nil))
+		assert.False(t, isEnforced(&Config{EnforcePercent: 0, EnforcePercentBy: EnforcePercentBySubject}, d, nil))
+	})
+
+	t.Run("unverified subjects are enforced", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{EnforcePercent: 0, EnforcePercentBy: EnforcePercentBySubject}
+
+		assert.True(t, isEnforced(cfg, authzDecision{subject: "user-1"}, nil))
+		assert.True(t, isEnforced(cfg, authzDecision{}, nil))
+	})
+
+	t.Run("subjects accepted by the permissions-api are bucketed", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{EnforcePercent: 0, EnforcePercentBy: EnforcePercentBySubject}
+
+		assert.False(t, isEnforced(cfg, authzDecision{subject: "user-1", subjectTrusted: true}, nil))
+	})
+
+	t.Run("token errors are enforced", func(t *testing.T) {
+		t.Parallel()
+
+		for _, by := range []string{EnforcePercentBySubject, EnforcePercentByRandom} {
+			cfg := &Config{EnforcePercent: 0, EnforcePercentBy: by}
+
+			assert.True(t, isEnforced(cfg, verified("user-1"), ErrNoValidToken), "missing token not enforced by %s", by)
+			assert.True(t, isEnforced(cfg, verified("user-1"), fmt.Errorf("%w: expired", ErrInvalidToken)), "invalid token not enforced by %s", by)
+			assert.False(t, isEnforced(cfg, verified("user-1"), ErrCircuitOpen), "authorization service error enforced by %s", by)
+		}
+	})
+
+	t.Run("deterministic per subject", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{EnforcePercent: 50, EnforcePercentBy: EnforcePercentBySubject}
+		enforced := 0
+
+		for i := 0; i < 1000; i++ {
+			d := verified(fmt.Sprintf("user-%d", i))
+			got := isEnforced(cfg, d, nil)
+
+			assert.Equal(t, got, isEnforced(cfg, d, nil), "subject %s bucketed differently", d.subject)
+
+			if got {
+				enforced++
+			}
+		}
+
+		assert.InDelta(t, 500, enforced, 100)
+	})
+
+	t.Run("monotonic in percent", func(t *testing.T) {
+		t.Parallel()
+
+		low := &Config{EnforcePercent: 20, EnforcePercentBy: EnforcePercentBySubject}
+		high := &Config{EnforcePercent: 80, EnforcePercentBy: EnforcePercentBySubject}
+
+		for i := 0; i < 100; i++ {
+			d := verified(fmt.Sprintf("user-%d", i))
+
+			if isEnforced(low, d, nil) {
+				assert.True(t, isEnforced(high, d, nil), "subject enforced at 20%% should be enforced at 80%%")
+			}
+		}
+	})
+
+	t.Run("random per request", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{EnforcePercent: 50, EnforcePercentBy: EnforcePercentByRandom}
+		enforced := 0
+
+		for i := 0; i < 1000; i++ {
+			if isEnforced(cfg, authzDecision{}, nil) {
+				enforced++
+			}
+		}
+
+		assert.InDelta(t, 500, enforced, 100)
+	})
+}
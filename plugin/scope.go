@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInsufficientScope is returned when the token was not granted the scopes required by the endpoint
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// tokenScopes returns the scopes of the given token claims. They're read from the scope claim,
+// a space-separated list (RFC 8693), or from the scp claim used by some providers, which is
+// either a space-separated list or a list of strings.
+func tokenScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	switch scp := claims["scp"].(type) {
+	case string:
+		return strings.Fields(scp)
+	case []interface{}:
+		scopes := make([]string, 0, len(scp))
+
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+
+		return scopes
+	default:
+		return nil
+	}
+}
+
+// missingScopes returns the required scopes which are not in the granted scopes
+func missingScopes(required, granted []string) []string {
+	var missing []string
+
+	for _, scope := range required {
+		if !contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
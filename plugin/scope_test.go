@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenScopes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		claims string
+		want   []string
+	}{
+		{
+			name:   "scope claim",
+			claims: `{"scope":"test:read  test:write"}`,
+			want:   []string{"test:read", "test:write"},
+		},
+		{
+			name:   "scp string claim",
+			claims: `{"scp":"test:read test:write"}`,
+			want:   []string{"test:read", "test:write"},
+		},
+		{
+			name:   "scp list claim",
+			claims: `{"scp":["test:read","test:write",1]}`,
+			want:   []string{"test:read", "test:write"},
+		},
+		{
+			name:   "scope claim preferred",
+			claims: `{"scope":"test:read","scp":["test:write"]}`,
+			want:   []string{"test:read"},
+		},
+		{
+			name:   "no scopes",
+			claims: `{"sub":"idntusr-1"}`,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tokenScopes(unverifiedClaims("Bearer "+testJWT(tt.claims))))
+		})
+	}
+}
+
+func TestHandleAuthorizationRequestRequiredScopes(t *testing.T) {
+	t.Parallel()
+
+	api := newFakePermissionsAPI(t)
+
+	resID := uuid.New()
+	api.allow("test_get", "urn:infratographer:test:"+resID.String())
+
+	cfg := newTestConfig(t, api.URL)
+	cfg.RequiredScopes = []string{"test:read", "test:list"}
+
+	h, err := newAuthzHandler(cfg)
+	require.NoError(t, err)
+
+	newReq := func(claims string) RequestWrapper {
+		return &testRequest{
+			method:  http.MethodGet,
+			headers: map[string][]string{AuthorizationHeader: {"Bearer " + testJWT(claims)}},
+			params:  map[string]string{"Test_id": resID.String()},
+		}
+	}
+
+	_, err = authorize(h, newReq(`{"sub":"idntusr-1","scope":"test:read"}`))
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+
+	_, err = authorize(h, newReq(`{"sub":"idntusr-1"}`))
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+
+	assert.Equal(t, 0, api.callCount(), "expected tokens missing scopes not to reach the permissions-api")
+
+	allowed, err := authorize(h, newReq(`{"sub":"idntusr-1","scp":["test:list","test:read"]}`))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, api.callCount())
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the porton spans
+const tracerName = "github.com/infratographer/porton"
+
+// tracePropagator extracts the W3C trace context and baggage of incoming requests and
+// injects them in the calls to the permissions-api. It's used instead of the global
+// propagator, which KrakenD may leave unset.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// contextWithTrace returns a copy of the context carrying the trace context found in the
+// headers of the request
+func contextWithTrace(ctx context.Context, req RequestWrapper) context.Context {
+	return tracePropagator.Extract(ctx, propagation.HeaderCarrier(req.Headers()))
+}
+
+// startAuthorizeSpan starts the span around the authorization of a request, using the
+// global tracer provider
+func startAuthorizeSpan(ctx context.Context, req RequestWrapper) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "porton.authorize",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method()),
+			attribute.String("http.target", req.Path()),
+		),
+	)
+}
+
+// endAuthorizeSpan records the decision of the audit event on the span and ends it
+func endAuthorizeSpan(span trace.Span, ev *AuditEvent, err error) {
+	actions := make([]string, 0, len(ev.Checks))
+	resources := make([]string, 0, len(ev.Checks))
+
+	for _, c := range ev.Checks {
+		actions = append(actions, c.Action)
+		resources = append(resources, c.Resource)
+	}
+
+	span.SetAttributes(
+		attribute.StringSlice("porton.actions", actions),
+		attribute.StringSlice("porton.resources", resources),
+		attribute.String("porton.decision", ev.Decision),
+		attribute.String("porton.reason", ev.Reason),
+		attribute.Bool("porton.enforced", ev.Enforced),
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, ev.Reason)
+	}
+
+	span.End()
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracePropagation(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+
+	gotTraceparent := make(chan string, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent <- r.Header.Get("Traceparent")
+
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(srv.Close)
+
+	handle := NewPortonRegisterer(PluginName).requestModPluginHandle(map[string]interface{}{
+		PluginName: map[string]interface{}{
+			"authz_service": map[string]interface{}{
+				"endpoint": srv.URL,
+			},
+			"action":         "test_get",
+			"resource_type":  "test",
+			"resource_param": "test_id",
+		},
+	})
+
+	req := &testRequest{
+		method: http.MethodGet,
+		headers: map[string][]string{
+			AuthorizationHeader: {"Bearer token"},
+			"Traceparent":       {traceparent},
+		},
+		params: map[string]string{"Test_id": uuid.NewString()},
+	}
+
+	_, err := handle(req)
+	require.NoError(t, err)
+
+	got := <-gotTraceparent
+	assert.True(t, strings.HasPrefix(got, "00-"+traceID+"-"), "expected the trace to be propagated to the permissions-api, got %q", got)
+}